@@ -0,0 +1,273 @@
+package s3
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	DefaultRegion  = "us-east-1"
+	metadataPrefix = "x-amz-meta-"
+)
+
+// ErrNotFound is returned when the requested object does not exist in the bucket.
+var ErrNotFound = fmt.Errorf("s3: object not found")
+
+type Config struct {
+	Endpoint   string // e.g. https://s3.amazonaws.com or http://localhost:9000 for MinIO
+	Region     string
+	Bucket     string
+	AccessKey  string
+	SecretKey  string
+	HTTPClient *http.Client
+}
+
+// Client is a minimal S3-compatible object storage client using path-style addressing and signature v4.
+type Client struct {
+	endpoint   *url.URL
+	region     string
+	bucket     string
+	accessKey  string
+	secretKey  string
+	httpClient *http.Client
+	now        func() time.Time
+}
+
+func NewClient(cfg *Config) (*Client, error) {
+	if cfg == nil {
+		return nil, fmt.Errorf("S3 Client Config is nil!")
+	}
+
+	if cfg.Endpoint == "" {
+		return nil, fmt.Errorf("cfg.Endpoint is blank!")
+	}
+
+	if cfg.Bucket == "" {
+		return nil, fmt.Errorf("cfg.Bucket is blank!")
+	}
+
+	endpoint, err := url.Parse(cfg.Endpoint)
+	if err != nil {
+		return nil, err
+	}
+
+	if cfg.Region == "" {
+		cfg.Region = DefaultRegion
+	}
+
+	if cfg.HTTPClient == nil {
+		cfg.HTTPClient = http.DefaultClient
+	}
+
+	return &Client{
+		endpoint:   endpoint,
+		region:     cfg.Region,
+		bucket:     cfg.Bucket,
+		accessKey:  cfg.AccessKey,
+		secretKey:  cfg.SecretKey,
+		httpClient: cfg.HTTPClient,
+		now:        time.Now,
+	}, nil
+}
+
+func (c *Client) objectURL(key string) string {
+	u := *c.endpoint
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/" + c.bucket + "/" + strings.TrimPrefix(key, "/")
+	// Send the path exactly as it is encoded for signing
+	u.RawPath = uriEncode(u.Path, false)
+	return u.String()
+}
+
+// NewRequest creates a request for the object, additional headers may be set before passing it to Do.
+func (c *Client) NewRequest(method, key string, body []byte) (*http.Request, error) {
+	req, err := http.NewRequest(method, c.objectURL(key), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+
+	sum := sha256.Sum256(body)
+	req.Header.Set("X-Amz-Content-Sha256", hex.EncodeToString(sum[:]))
+	return req, nil
+}
+
+// Do signs and sends the request. Non-2xx responses other than 304 are converted to errors.
+func (c *Client) Do(req *http.Request) (*http.Response, error) {
+	if req.Header.Get("X-Amz-Content-Sha256") == "" {
+		req.Header.Set("X-Amz-Content-Sha256", emptyPayloadHash)
+	}
+
+	if c.accessKey != "" {
+		sign(req, c.accessKey, c.secretKey, c.region, c.now())
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode == http.StatusNotFound {
+		resp.Body.Close()
+		return nil, ErrNotFound
+	}
+
+	if resp.StatusCode >= 300 && resp.StatusCode != http.StatusNotModified {
+		defer resp.Body.Close()
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return nil, fmt.Errorf("s3: %s %s: %s: %s", req.Method, req.URL.Path, resp.Status, strings.TrimSpace(string(msg)))
+	}
+
+	return resp, nil
+}
+
+// GetObject returns the response for the object, the caller is responsible for closing its body.
+func (c *Client) GetObject(key string) (*http.Response, error) {
+	req, err := c.NewRequest(http.MethodGet, key, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.Do(req)
+}
+
+// Metadata returns the user defined metadata of an object response.
+func Metadata(header http.Header) map[string]string {
+	metadata := map[string]string{}
+	for key, values := range header {
+		if name, ok := strings.CutPrefix(strings.ToLower(key), metadataPrefix); ok && len(values) > 0 {
+			metadata[name] = values[0]
+		}
+	}
+	return metadata
+}
+
+func setMetadata(req *http.Request, metadata map[string]string) {
+	for name, value := range metadata {
+		req.Header.Set(metadataPrefix+strings.ToLower(name), value)
+	}
+}
+
+// PutObject stores the object along with user defined metadata, which is returned as x-amz-meta-* headers.
+func (c *Client) PutObject(key string, data []byte, contentType string, metadata map[string]string) error {
+	req, err := c.NewRequest(http.MethodPut, key, data)
+	if err != nil {
+		return err
+	}
+
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	setMetadata(req, metadata)
+
+	resp, err := c.Do(req)
+	if err != nil {
+		return err
+	}
+
+	return resp.Body.Close()
+}
+
+// HeadObject returns the response headers of the object without its body.
+func (c *Client) HeadObject(key string) (http.Header, error) {
+	req, err := c.NewRequest(http.MethodHead, key, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := c.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+
+	return resp.Header, nil
+}
+
+// CopyObject copies an object within the bucket, replacing its user defined metadata. Copying an object onto itself
+// updates its metadata without uploading it again.
+func (c *Client) CopyObject(source, key string, metadata map[string]string) error {
+	req, err := c.NewRequest(http.MethodPut, key, nil)
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("X-Amz-Copy-Source", "/"+c.bucket+"/"+uriEncode(strings.TrimPrefix(source, "/"), false))
+	req.Header.Set("X-Amz-Metadata-Directive", "REPLACE")
+	setMetadata(req, metadata)
+
+	resp, err := c.Do(req)
+	if err != nil {
+		return err
+	}
+
+	return resp.Body.Close()
+}
+
+type listBucketResult struct {
+	Contents []struct {
+		Key string `xml:"Key"`
+	} `xml:"Contents"`
+	IsTruncated           bool   `xml:"IsTruncated"`
+	NextContinuationToken string `xml:"NextContinuationToken"`
+}
+
+// ListObjects returns the keys of every object starting with the prefix, following continuation tokens.
+func (c *Client) ListObjects(prefix string) ([]string, error) {
+	var keys []string
+	token := ""
+
+	for {
+		req, err := c.NewRequest(http.MethodGet, "", nil)
+		if err != nil {
+			return nil, err
+		}
+
+		query := url.Values{"list-type": {"2"}, "prefix": {prefix}}
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+		req.URL.RawQuery = canonicalQuery(query)
+
+		resp, err := c.Do(req)
+		if err != nil {
+			return nil, err
+		}
+
+		result := &listBucketResult{}
+		err = xml.NewDecoder(resp.Body).Decode(result)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		for _, object := range result.Contents {
+			keys = append(keys, object.Key)
+		}
+
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			return keys, nil
+		}
+		token = result.NextContinuationToken
+	}
+}
+
+func (c *Client) DeleteObject(key string) error {
+	req, err := c.NewRequest(http.MethodDelete, key, nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := c.Do(req)
+	if err != nil {
+		return err
+	}
+
+	return resp.Body.Close()
+}
@@ -0,0 +1,190 @@
+// Package s3test provides an in-memory stand-in for an S3-compatible server such as MinIO.
+package s3test
+
+import (
+	"crypto/md5"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type Object struct {
+	Data         []byte
+	ContentType  string
+	Metadata     map[string]string
+	ETag         string
+	LastModified time.Time
+}
+
+// Server serves objects with path-style addressing (/bucket/key). Requests must be signed but signatures aren't
+// verified.
+type Server struct {
+	*httptest.Server
+	mu       sync.Mutex
+	objects  map[string]*Object
+	requests map[string]int
+}
+
+func NewServer() *Server {
+	s := &Server{
+		objects:  map[string]*Object{},
+		requests: map[string]int{},
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.handle))
+	return s
+}
+
+func newObject(data []byte, contentType string, metadata map[string]string) *Object {
+	return &Object{
+		Data:         data,
+		ContentType:  contentType,
+		Metadata:     metadata,
+		ETag:         fmt.Sprintf(`"%x"`, md5.Sum(data)),
+		LastModified: time.Now().UTC().Truncate(time.Second),
+	}
+}
+
+// Put stores an object directly, e.g. to seed originals.
+func (s *Server) Put(bucket, key string, data []byte, contentType string) *Object {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	object := newObject(data, contentType, map[string]string{})
+	s.objects["/"+bucket+"/"+key] = object
+	return object
+}
+
+// Object returns a stored object, or nil.
+func (s *Server) Object(bucket, key string) *Object {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.objects["/"+bucket+"/"+key]
+}
+
+// Requests returns how many requests were made with the method.
+func (s *Server) Requests(method string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.requests[method]
+}
+
+func (s *Server) handle(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.requests[r.Method]++
+
+	if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 ") {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	path := r.URL.Path
+	object := s.objects[path]
+
+	if r.Method == http.MethodGet && r.URL.Query().Get("list-type") == "2" {
+		s.list(w, r)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		if object == nil {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		w.Header().Set("ETag", object.ETag)
+		w.Header().Set("Last-Modified", object.LastModified.Format(http.TimeFormat))
+		if object.ContentType != "" {
+			w.Header().Set("Content-Type", object.ContentType)
+		}
+		for name, value := range object.Metadata {
+			w.Header().Set("X-Amz-Meta-"+name, value)
+		}
+
+		if r.Header.Get("If-None-Match") == object.ETag {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+
+		w.Header().Set("Content-Length", fmt.Sprintf("%d", len(object.Data)))
+		if r.Method == http.MethodGet {
+			_, _ = w.Write(object.Data)
+		}
+	case http.MethodPut:
+		metadata := map[string]string{}
+		for key, values := range r.Header {
+			if name, ok := strings.CutPrefix(strings.ToLower(key), "x-amz-meta-"); ok {
+				metadata[name] = values[0]
+			}
+		}
+
+		if source := r.Header.Get("X-Amz-Copy-Source"); source != "" {
+			source, _ = url.PathUnescape(source)
+			copied := s.objects[source]
+			if copied == nil {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			s.objects[path] = newObject(copied.Data, copied.ContentType, metadata)
+			return
+		}
+
+		data, err := io.ReadAll(r.Body)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		s.objects[path] = newObject(data, r.Header.Get("Content-Type"), metadata)
+	case http.MethodDelete:
+		delete(s.objects, path)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// Lists the objects of the bucket in the path matching the prefix, in pages of two to exercise continuation.
+func (s *Server) list(w http.ResponseWriter, r *http.Request) {
+	bucket := "/" + strings.Trim(r.URL.Path, "/") + "/"
+	prefix := r.URL.Query().Get("prefix")
+
+	var keys []string
+	for path := range s.objects {
+		if key, ok := strings.CutPrefix(path, bucket); ok && strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	start := 0
+	if token := r.URL.Query().Get("continuation-token"); token != "" {
+		start, _ = strconv.Atoi(token)
+	}
+	end := min(start+2, len(keys))
+
+	w.Header().Set("Content-Type", "application/xml")
+	fmt.Fprint(w, `<?xml version="1.0" encoding="UTF-8"?><ListBucketResult>`)
+	for _, key := range keys[start:end] {
+		fmt.Fprint(w, "<Contents><Key>")
+		_ = xml.EscapeText(w, []byte(key))
+		fmt.Fprint(w, "</Key></Contents>")
+	}
+	if end < len(keys) {
+		fmt.Fprintf(w, "<IsTruncated>true</IsTruncated><NextContinuationToken>%d</NextContinuationToken>", end)
+	} else {
+		fmt.Fprint(w, "<IsTruncated>false</IsTruncated>")
+	}
+	fmt.Fprint(w, "</ListBucketResult>")
+}
@@ -0,0 +1,231 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"runtime"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	imageHandler "antman-proxy/handlers/image"
+	cacheManager "antman-proxy/managers/cache"
+	imageManager "antman-proxy/managers/image"
+)
+
+const (
+	DefaultWebhookTimeout  = 10 * time.Second
+	DefaultMaxWarmVariants = 1000
+)
+
+type Config struct {
+	ImageManager    imageManager.Manager
+	PurgeWebhook    string       // Optional URL notified of purged images, e.g. to purge them from the CDN
+	WebhookToken    string       // Optional bearer token sent to the purge webhook
+	HTTPClient      *http.Client // Defaults to a client with DefaultWebhookTimeout
+	Presets         map[string]imageHandler.Preset
+	WarmConcurrency int                                   // Number of variants processed at once by a warm request, defaults to the number of CPUs
+	MaxWarmVariants int                                   // Upper bound of the variants generated by a single warm request
+	Caches          map[string]cacheManager.StatsReporter // Caches reported by the stats and metrics endpoints, by name
+	WorkerPool      *imageHandler.WorkerPool              // Pool of the resize endpoint, reported by the stats and metrics endpoints and warming at background priority
+}
+
+type AdminHandler struct {
+	imageManager    imageManager.Manager
+	purgeWebhook    string
+	webhookToken    string
+	httpClient      *http.Client
+	presets         map[string]imageHandler.Preset
+	warmConcurrency int
+	maxWarmVariants int
+	caches          map[string]cacheManager.StatsReporter
+	workerPool      *imageHandler.WorkerPool
+}
+
+type warmRequest struct {
+	URLs    []string `json:"urls" binding:"required"`
+	Presets []string `json:"presets"` // Defaults to every preset
+}
+
+func NewHandler(cfg *Config) (Handler, error) {
+	if cfg == nil {
+		return nil, fmt.Errorf("AdminHandler Config is nil!")
+	}
+
+	if cfg.ImageManager == nil {
+		return nil, fmt.Errorf("cfg.ImageManager is nil!")
+	}
+
+	if cfg.WorkerPool == nil {
+		return nil, fmt.Errorf("cfg.WorkerPool is nil!")
+	}
+
+	if cfg.HTTPClient == nil {
+		cfg.HTTPClient = &http.Client{Timeout: DefaultWebhookTimeout}
+	}
+
+	if cfg.WarmConcurrency <= 0 {
+		cfg.WarmConcurrency = runtime.NumCPU()
+	}
+
+	if cfg.MaxWarmVariants <= 0 {
+		cfg.MaxWarmVariants = DefaultMaxWarmVariants
+	}
+
+	return &AdminHandler{
+		imageManager:    cfg.ImageManager,
+		purgeWebhook:    cfg.PurgeWebhook,
+		webhookToken:    cfg.WebhookToken,
+		httpClient:      cfg.HTTPClient,
+		presets:         cfg.Presets,
+		warmConcurrency: cfg.WarmConcurrency,
+		maxWarmVariants: cfg.MaxWarmVariants,
+		caches:          cfg.Caches,
+		workerPool:      cfg.WorkerPool,
+	}, nil
+}
+
+// Resolves the image reference of the request the same way the resize endpoint does.
+func imageURL(c *gin.Context) string {
+	if src := c.Query("src"); src != "" {
+		return src
+	}
+	if path := c.Query("path"); path != "" {
+		return imageManager.LocalOrigin + ":" + path
+	}
+	return c.Query("url")
+}
+
+// HandlePurge removes every cached variant of an image. When a purge webhook is configured it's notified afterwards,
+// a failed notification is reported with a 502 so the purge can be retried.
+func (h *AdminHandler) HandlePurge(c *gin.Context) {
+	url := imageURL(c)
+	if url == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Missing URL parameter"})
+		return
+	}
+
+	purged, err := h.imageManager.Purge(url)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	if h.purgeWebhook != "" {
+		err = h.notify(url)
+		if err != nil {
+			c.JSON(http.StatusBadGateway, gin.H{"purged": purged, "error": fmt.Sprintf("Purge webhook failed: %s", err)})
+			return
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{"purged": purged})
+}
+
+// HandleWarm pre-generates the variants of every preset requested for every image, responding with a report of the
+// failures once they're all processed.
+func (h *AdminHandler) HandleWarm(c *gin.Context) {
+	request := &warmRequest{}
+	err := c.ShouldBindJSON(request)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	names := request.Presets
+	if len(names) == 0 {
+		for name := range h.presets {
+			names = append(names, name)
+		}
+	}
+
+	if len(names) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "No presets configured"})
+		return
+	}
+
+	variants := map[string]imageManager.Variant{}
+	for _, name := range names {
+		preset, ok := h.presets[name]
+		if !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Unknown preset: %s", name)})
+			return
+		}
+		variants[name] = preset.Variant()
+	}
+
+	if len(request.URLs)*len(variants) > h.maxWarmVariants {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("At most %d variants can be warmed at once", h.maxWarmVariants)})
+		return
+	}
+
+	// Warming outlasts the server's write timeout, so it is lifted for this response
+	_ = http.NewResponseController(c.Writer).SetWriteDeadline(time.Time{})
+
+	// Warming yields the workers to the resize endpoint
+	manager := imageHandler.NewPooledManager(h.imageManager, h.workerPool, imageHandler.PriorityBackground)
+
+	c.JSON(http.StatusOK, imageManager.Warm(c.Request.Context(), manager, request.URLs, variants, h.warmConcurrency))
+}
+
+type statsResponse struct {
+	Caches     map[string]cacheManager.Stats `json:"caches"`
+	WorkerPool imageHandler.PoolStats        `json:"worker_pool"`
+}
+
+func (h *AdminHandler) stats() *statsResponse {
+	stats := &statsResponse{Caches: map[string]cacheManager.Stats{}, WorkerPool: h.workerPool.Stats()}
+	for name, cache := range h.caches {
+		stats.Caches[name] = cache.Stats()
+	}
+
+	return stats
+}
+
+// HandleStats responds with the statistics of every cache by name and the load of the worker pool.
+func (h *AdminHandler) HandleStats(c *gin.Context) {
+	c.JSON(http.StatusOK, h.stats())
+}
+
+// HandleMetrics exposes the statistics of HandleStats in the Prometheus text format.
+func (h *AdminHandler) HandleMetrics(c *gin.Context) {
+	stats := h.stats()
+
+	c.Header("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	c.Status(http.StatusOK)
+	writeMetrics(c.Writer, stats.Caches)
+	writePoolMetrics(c.Writer, &stats.WorkerPool)
+}
+
+func (h *AdminHandler) notify(url string) error {
+	body, err := json.Marshal(map[string]string{"url": url})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, h.purgeWebhook, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	if h.webhookToken != "" {
+		req.Header.Set("Authorization", "Bearer "+h.webhookToken)
+	}
+
+	resp, err := h.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+
+	return nil
+}
@@ -0,0 +1,408 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	imageHandler "antman-proxy/handlers/image"
+	cacheManager "antman-proxy/managers/cache"
+	imageManager "antman-proxy/managers/image/mock_manager"
+)
+
+const testURL = "http://imgur.com/image.jpg"
+
+func setupTest(t *testing.T) (*gomock.Controller, *imageManager.MockManager, *gin.Engine) {
+	ctrl := gomock.NewController(t)
+	mockManager := imageManager.NewMockManager(ctrl)
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+
+	return ctrl, mockManager, router
+}
+
+// Returns a worker pool shut down once the test ends.
+func newTestPool(t *testing.T) *imageHandler.WorkerPool {
+	pool := imageHandler.NewWorkerPool(&imageHandler.WorkerPoolConfig{Workers: 2})
+	t.Cleanup(func() { pool.Shutdown(context.Background()) })
+	return pool
+}
+
+func TestAdminHandler_NewHandler(t *testing.T) {
+	ctrl, mockManager, _ := setupTest(t)
+	defer ctrl.Finish()
+
+	_, err := NewHandler(nil)
+	assert.Equal(t, "AdminHandler Config is nil!", err.Error())
+
+	_, err = NewHandler(&Config{})
+	assert.Equal(t, "cfg.ImageManager is nil!", err.Error())
+
+	_, err = NewHandler(&Config{ImageManager: mockManager})
+	assert.Equal(t, "cfg.WorkerPool is nil!", err.Error())
+}
+
+func TestAdminHandler_HandlePurge(t *testing.T) {
+	ctrl, mockManager, router := setupTest(t)
+	defer ctrl.Finish()
+
+	handler, err := NewHandler(&Config{ImageManager: mockManager, WorkerPool: newTestPool(t)})
+	require.NoError(t, err)
+
+	router.DELETE("/admin/cache", handler.HandlePurge)
+
+	tests := []struct {
+		name         string
+		query        string
+		expectedURL  string
+		purgeErr     error
+		expectedCode int
+		expectedBody string
+	}{
+		{
+			name:         "purges by url",
+			query:        "url=" + testURL,
+			expectedURL:  testURL,
+			expectedCode: http.StatusOK,
+			expectedBody: `{"purged":3}`,
+		},
+		{
+			name:         "purges by origin reference",
+			query:        "src=bucket:products/123.jpg",
+			expectedURL:  "bucket:products/123.jpg",
+			expectedCode: http.StatusOK,
+			expectedBody: `{"purged":3}`,
+		},
+		{
+			name:         "purges by local path",
+			query:        "path=products/123.jpg",
+			expectedURL:  "local:products/123.jpg",
+			expectedCode: http.StatusOK,
+			expectedBody: `{"purged":3}`,
+		},
+		{
+			name:         "missing url",
+			query:        "",
+			expectedCode: http.StatusBadRequest,
+			expectedBody: `{"error":"Missing URL parameter"}`,
+		},
+		{
+			name:         "purge fails",
+			query:        "url=" + testURL,
+			expectedURL:  testURL,
+			purgeErr:     errors.New("cache unavailable"),
+			expectedCode: http.StatusInternalServerError,
+			expectedBody: `{"error":"cache unavailable"}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.expectedURL != "" {
+				mockManager.EXPECT().Purge(tt.expectedURL).Return(3, tt.purgeErr)
+			}
+
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest("DELETE", "/admin/cache?"+tt.query, nil)
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, tt.expectedCode, w.Code)
+			assert.JSONEq(t, tt.expectedBody, w.Body.String())
+		})
+	}
+}
+
+func TestAdminHandler_HandlePurge_Webhook(t *testing.T) {
+	ctrl, mockManager, router := setupTest(t)
+	defer ctrl.Finish()
+
+	status := http.StatusOK
+	var received map[string]string
+	var authorization string
+
+	webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authorization = r.Header.Get("Authorization")
+		body, _ := io.ReadAll(r.Body)
+		_ = json.Unmarshal(body, &received)
+		w.WriteHeader(status)
+	}))
+	defer webhook.Close()
+
+	handler, err := NewHandler(&Config{ImageManager: mockManager, PurgeWebhook: webhook.URL, WebhookToken: "cdn-token", WorkerPool: newTestPool(t)})
+	require.NoError(t, err)
+
+	router.DELETE("/admin/cache", handler.HandlePurge)
+
+	t.Run("notifies the webhook", func(t *testing.T) {
+		mockManager.EXPECT().Purge(testURL).Return(2, nil)
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("DELETE", "/admin/cache?url="+testURL, nil))
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, map[string]string{"url": testURL}, received)
+		assert.Equal(t, "Bearer cdn-token", authorization)
+	})
+
+	t.Run("webhook failure", func(t *testing.T) {
+		status = http.StatusServiceUnavailable
+		mockManager.EXPECT().Purge(testURL).Return(2, nil)
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("DELETE", "/admin/cache?url="+testURL, nil))
+
+		assert.Equal(t, http.StatusBadGateway, w.Code)
+		assert.Contains(t, w.Body.String(), `"purged":2`)
+		assert.Contains(t, w.Body.String(), "503 Service Unavailable")
+	})
+}
+
+func TestAdminHandler_HandleWarm(t *testing.T) {
+	ctrl, mockManager, router := setupTest(t)
+	defer ctrl.Finish()
+
+	presets := map[string]imageHandler.Preset{
+		"thumb": {Width: 320, Height: 320, Quality: 70, Format: "webp"},
+		"hero":  {Width: 1600},
+	}
+
+	pool := newTestPool(t)
+	handler, err := NewHandler(&Config{
+		ImageManager:    mockManager,
+		Presets:         presets,
+		WarmConcurrency: 2,
+		MaxWarmVariants: 4,
+		WorkerPool:      pool,
+	})
+	require.NoError(t, err)
+
+	router.POST("/admin/warm", handler.HandleWarm)
+
+	t.Run("warms every preset by default", func(t *testing.T) {
+		for _, url := range []string{testURL, "http://imgur.com/other.jpg"} {
+			mockManager.EXPECT().IsURLAllowed(url).Return(true).Times(2)
+			mockManager.EXPECT().ProcessImage(gomock.Any(), url, 320, 320, "webp", 70).Return(newEntry(), nil)
+		}
+		mockManager.EXPECT().ProcessImage(gomock.Any(), testURL, 1600, 0, "jpeg", 85).Return(newEntry(), nil)
+		mockManager.EXPECT().ProcessImage(gomock.Any(), "http://imgur.com/other.jpg", 1600, 0, "jpeg", 85).Return(nil, errors.New("processing failed"))
+
+		w := httptest.NewRecorder()
+		body := `{"urls": ["` + testURL + `", "http://imgur.com/other.jpg"]}`
+		router.ServeHTTP(w, httptest.NewRequest("POST", "/admin/warm", strings.NewReader(body)))
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.JSONEq(t, `{
+			"succeeded": 3,
+			"failed": 1,
+			"failures": [{"url": "http://imgur.com/other.jpg", "variant": "hero", "error": "processing failed"}]
+		}`, w.Body.String())
+	})
+
+	t.Run("warms selected presets", func(t *testing.T) {
+		mockManager.EXPECT().IsURLAllowed(testURL).Return(true)
+		mockManager.EXPECT().ProcessImage(gomock.Any(), testURL, 320, 320, "webp", 70).Return(newEntry(), nil)
+
+		w := httptest.NewRecorder()
+		body := `{"urls": ["` + testURL + `"], "presets": ["thumb"]}`
+		router.ServeHTTP(w, httptest.NewRequest("POST", "/admin/warm", strings.NewReader(body)))
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.JSONEq(t, `{"succeeded": 1, "failed": 0}`, w.Body.String())
+
+		// Every variant was processed by the workers at background priority
+		assert.Equal(t, int64(5), pool.Stats().Lanes["background"].Started)
+	})
+
+	t.Run("warms more variants than the queue holds", func(t *testing.T) {
+		pool := imageHandler.NewWorkerPool(&imageHandler.WorkerPoolConfig{Workers: 1, QueueSize: 1})
+		defer pool.Shutdown(context.Background())
+
+		handler, err := NewHandler(&Config{ImageManager: mockManager, Presets: presets, WarmConcurrency: 8, WorkerPool: pool})
+		require.NoError(t, err)
+
+		router := gin.New()
+		router.POST("/admin/warm", handler.HandleWarm)
+
+		urls := make([]string, 8)
+		for i := range urls {
+			urls[i] = fmt.Sprintf("http://imgur.com/%d.jpg", i)
+			mockManager.EXPECT().IsURLAllowed(urls[i]).Return(true)
+			mockManager.EXPECT().ProcessImage(gomock.Any(), urls[i], 320, 320, "webp", 70).DoAndReturn(
+				func(ctx context.Context, imageURL string, width, height int, format string, quality int) (*cacheManager.Entry, error) {
+					time.Sleep(time.Millisecond)
+					return newEntry(), nil
+				})
+		}
+
+		w := httptest.NewRecorder()
+		body := `{"urls": ["` + strings.Join(urls, `", "`) + `"], "presets": ["thumb"]}`
+		router.ServeHTTP(w, httptest.NewRequest("POST", "/admin/warm", strings.NewReader(body)))
+
+		// Variants wait for room in the background queue instead of being shed
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.JSONEq(t, `{"succeeded": 8, "failed": 0}`, w.Body.String())
+		assert.Zero(t, pool.Stats().Rejected)
+	})
+
+	tests := []struct {
+		name          string
+		body          string
+		expectedError string
+	}{
+		{
+			name:          "missing urls",
+			body:          `{"presets": ["thumb"]}`,
+			expectedError: "required",
+		},
+		{
+			name:          "unknown preset",
+			body:          `{"urls": ["` + testURL + `"], "presets": ["banner"]}`,
+			expectedError: "Unknown preset: banner",
+		},
+		{
+			name:          "too many variants",
+			body:          `{"urls": ["a", "b", "c"]}`,
+			expectedError: "At most 4 variants can be warmed at once",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, httptest.NewRequest("POST", "/admin/warm", strings.NewReader(tt.body)))
+
+			assert.Equal(t, http.StatusBadRequest, w.Code)
+			assert.Contains(t, w.Body.String(), tt.expectedError)
+		})
+	}
+
+	t.Run("no presets configured", func(t *testing.T) {
+		handler, err := NewHandler(&Config{ImageManager: mockManager, WorkerPool: pool})
+		require.NoError(t, err)
+
+		router := gin.New()
+		router.POST("/admin/warm", handler.HandleWarm)
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("POST", "/admin/warm", strings.NewReader(`{"urls": ["`+testURL+`"]}`)))
+
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		assert.Contains(t, w.Body.String(), "No presets configured")
+	})
+}
+
+// Reports fixed statistics
+type fixedStats cacheManager.Stats
+
+func (s fixedStats) Stats() cacheManager.Stats {
+	return cacheManager.Stats(s)
+}
+
+func testCaches() map[string]cacheManager.StatsReporter {
+	return map[string]cacheManager.StatsReporter{
+		"variants": fixedStats{
+			Hits:        8,
+			Misses:      2,
+			Expirations: 1,
+			Bytes:       30,
+			Entries:     2,
+			Formats:     map[string]cacheManager.FormatStats{"webp": {Bytes: 10, Entries: 1}, "jpeg": {Bytes: 20, Entries: 1}},
+			Ages:        []cacheManager.AgeBucket{{MaxAge: 60, Entries: 1}, {MaxAge: 3600, Entries: 2}},
+			AgeSum:      1230.5,
+		},
+		"memory": fixedStats{Hits: 5, Misses: 3, Evictions: 4, Formats: map[string]cacheManager.FormatStats{}},
+	}
+}
+
+func TestAdminHandler_HandleStats(t *testing.T) {
+	ctrl, mockManager, router := setupTest(t)
+	defer ctrl.Finish()
+
+	pool := imageHandler.NewWorkerPool(&imageHandler.WorkerPoolConfig{Workers: 2})
+	defer pool.Shutdown(context.Background())
+
+	handler, err := NewHandler(&Config{ImageManager: mockManager, Caches: testCaches(), WorkerPool: pool})
+	require.NoError(t, err)
+
+	router.GET("/admin/stats", handler.HandleStats)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/admin/stats", nil))
+
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	stats := statsResponse{}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &stats))
+	assert.Equal(t, cacheManager.Stats(testCaches()["variants"].(fixedStats)), stats.Caches["variants"])
+	assert.Equal(t, int64(4), stats.Caches["memory"].Evictions)
+	assert.Equal(t, imageHandler.PoolStats{
+		Workers:    2,
+		MinWorkers: 2,
+		MaxWorkers: 2,
+		Lanes: map[string]imageHandler.LaneStats{
+			"interactive": {},
+			"background":  {},
+		},
+		QueueSize: 4,
+	}, stats.WorkerPool)
+}
+
+func TestAdminHandler_HandleMetrics(t *testing.T) {
+	ctrl, mockManager, router := setupTest(t)
+	defer ctrl.Finish()
+
+	pool := imageHandler.NewWorkerPool(&imageHandler.WorkerPoolConfig{Workers: 2})
+	defer pool.Shutdown(context.Background())
+
+	handler, err := NewHandler(&Config{ImageManager: mockManager, Caches: testCaches(), WorkerPool: pool})
+	require.NoError(t, err)
+
+	router.GET("/admin/metrics", handler.HandleMetrics)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/admin/metrics", nil))
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "text/plain; version=0.0.4; charset=utf-8", w.Header().Get("Content-Type"))
+
+	body := w.Body.String()
+	for _, line := range []string{
+		"# TYPE antman_cache_hits_total counter",
+		`antman_cache_hits_total{cache="memory"} 5` + "\n" + `antman_cache_hits_total{cache="variants"} 8`,
+		`antman_cache_evictions_total{cache="memory"} 4`,
+		`antman_cache_bytes{cache="variants"} 30`,
+		`antman_cache_format_bytes{cache="variants",format="jpeg"} 20` + "\n" + `antman_cache_format_bytes{cache="variants",format="webp"} 10`,
+		"# TYPE antman_cache_entry_age_seconds histogram",
+		`antman_cache_entry_age_seconds_bucket{cache="variants",le="60"} 1`,
+		`antman_cache_entry_age_seconds_bucket{cache="variants",le="3600"} 2`,
+		`antman_cache_entry_age_seconds_bucket{cache="variants",le="+Inf"} 2`,
+		`antman_cache_entry_age_seconds_sum{cache="variants"} 1230.5`,
+		`antman_cache_entry_age_seconds_count{cache="variants"} 2`,
+		"antman_pool_workers 2",
+		"antman_pool_workers_max 2",
+		"antman_pool_queue_depth 0",
+		`antman_pool_lane_queue_depth{lane="background"} 0`,
+		`antman_pool_lane_started_total{lane="interactive"} 0`,
+		"# TYPE antman_pool_queue_wait_seconds summary",
+		`antman_pool_rejections_total{reason="queue_full"} 0`,
+	} {
+		assert.Contains(t, body, line)
+	}
+}
+
+func newEntry() *cacheManager.Entry {
+	return cacheManager.NewEntry([]byte("processed image"), "jpeg", time.Now(), testURL, nil)
+}
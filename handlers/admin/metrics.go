@@ -0,0 +1,179 @@
+package handlers
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+
+	imageHandler "antman-proxy/handlers/image"
+	cacheManager "antman-proxy/managers/cache"
+)
+
+type metric struct {
+	name   string
+	kind   string
+	help   string
+	values func(cache string, stats *cacheManager.Stats, w io.Writer)
+}
+
+func scalar(name string, value func(stats *cacheManager.Stats) float64) func(string, *cacheManager.Stats, io.Writer) {
+	return func(cache string, stats *cacheManager.Stats, w io.Writer) {
+		fmt.Fprintf(w, "%s{cache=%q} %s\n", name, cache, formatFloat(value(stats)))
+	}
+}
+
+func perFormat(name string, value func(stats cacheManager.FormatStats) float64) func(string, *cacheManager.Stats, io.Writer) {
+	return func(cache string, stats *cacheManager.Stats, w io.Writer) {
+		for _, format := range sortedKeys(stats.Formats) {
+			fmt.Fprintf(w, "%s{cache=%q,format=%q} %s\n", name, cache, format, formatFloat(value(stats.Formats[format])))
+		}
+	}
+}
+
+func ageHistogram(cache string, stats *cacheManager.Stats, w io.Writer) {
+	const name = "antman_cache_entry_age_seconds"
+	for _, bucket := range stats.Ages {
+		fmt.Fprintf(w, "%s_bucket{cache=%q,le=%q} %d\n", name, cache, formatFloat(bucket.MaxAge), bucket.Entries)
+	}
+	fmt.Fprintf(w, "%s_bucket{cache=%q,le=\"+Inf\"} %d\n", name, cache, stats.Entries)
+	fmt.Fprintf(w, "%s_sum{cache=%q} %s\n", name, cache, formatFloat(stats.AgeSum))
+	fmt.Fprintf(w, "%s_count{cache=%q} %d\n", name, cache, stats.Entries)
+}
+
+var metrics = []metric{
+	{
+		name:   "antman_cache_hits_total",
+		kind:   "counter",
+		help:   "Lookups answered by the cache.",
+		values: scalar("antman_cache_hits_total", func(s *cacheManager.Stats) float64 { return float64(s.Hits) }),
+	},
+	{
+		name:   "antman_cache_misses_total",
+		kind:   "counter",
+		help:   "Lookups the cache couldn't answer.",
+		values: scalar("antman_cache_misses_total", func(s *cacheManager.Stats) float64 { return float64(s.Misses) }),
+	},
+	{
+		name:   "antman_cache_expirations_total",
+		kind:   "counter",
+		help:   "Expired entries removed from the cache.",
+		values: scalar("antman_cache_expirations_total", func(s *cacheManager.Stats) float64 { return float64(s.Expirations) }),
+	},
+	{
+		name:   "antman_cache_evictions_total",
+		kind:   "counter",
+		help:   "Entries removed to keep the cache within its bounds.",
+		values: scalar("antman_cache_evictions_total", func(s *cacheManager.Stats) float64 { return float64(s.Evictions) }),
+	},
+	{
+		name:   "antman_cache_bytes",
+		kind:   "gauge",
+		help:   "Size of the cached entries.",
+		values: scalar("antman_cache_bytes", func(s *cacheManager.Stats) float64 { return float64(s.Bytes) }),
+	},
+	{
+		name:   "antman_cache_entries",
+		kind:   "gauge",
+		help:   "Number of cached entries.",
+		values: scalar("antman_cache_entries", func(s *cacheManager.Stats) float64 { return float64(s.Entries) }),
+	},
+	{
+		name:   "antman_cache_format_bytes",
+		kind:   "gauge",
+		help:   "Size of the cached entries by format.",
+		values: perFormat("antman_cache_format_bytes", func(s cacheManager.FormatStats) float64 { return float64(s.Bytes) }),
+	},
+	{
+		name:   "antman_cache_format_entries",
+		kind:   "gauge",
+		help:   "Number of cached entries by format.",
+		values: perFormat("antman_cache_format_entries", func(s cacheManager.FormatStats) float64 { return float64(s.Entries) }),
+	},
+	{
+		name:   "antman_cache_entry_age_seconds",
+		kind:   "histogram",
+		help:   "Time since the cached entries were stored or revalidated.",
+		values: ageHistogram,
+	},
+}
+
+// Writes the statistics of the caches in the Prometheus text exposition format, caches are sorted by name so the
+// output is stable.
+func writeMetrics(w io.Writer, stats map[string]cacheManager.Stats) {
+	caches := sortedKeys(stats)
+
+	for _, metric := range metrics {
+		writeHeader(w, metric.name, metric.kind, metric.help)
+		for _, cache := range caches {
+			cacheStats := stats[cache]
+			metric.values(cache, &cacheStats, w)
+		}
+	}
+}
+
+// Writes the load of the worker pool in the Prometheus text exposition format.
+func writePoolMetrics(w io.Writer, stats *imageHandler.PoolStats) {
+	writeHeader(w, "antman_pool_workers", "gauge", "Number of workers processing images.")
+	fmt.Fprintf(w, "antman_pool_workers %d\n", stats.Workers)
+
+	writeHeader(w, "antman_pool_workers_min", "gauge", "Lower bound of the workers the pool scales within.")
+	fmt.Fprintf(w, "antman_pool_workers_min %d\n", stats.MinWorkers)
+
+	writeHeader(w, "antman_pool_workers_max", "gauge", "Upper bound of the workers the pool scales within.")
+	fmt.Fprintf(w, "antman_pool_workers_max %d\n", stats.MaxWorkers)
+
+	writeHeader(w, "antman_pool_cpu_saturation", "gauge", "Share of the CPUs busy when the workers were last scaled.")
+	fmt.Fprintf(w, "antman_pool_cpu_saturation %s\n", formatFloat(stats.CPUSaturation))
+
+	writeHeader(w, "antman_pool_queue_depth", "gauge", "Jobs waiting for a worker.")
+	fmt.Fprintf(w, "antman_pool_queue_depth %d\n", stats.QueueDepth)
+
+	writeHeader(w, "antman_pool_lane_queue_depth", "gauge", "Jobs waiting for a worker by priority.")
+	for _, lane := range sortedKeys(stats.Lanes) {
+		fmt.Fprintf(w, "antman_pool_lane_queue_depth{lane=%q} %d\n", lane, stats.Lanes[lane].QueueDepth)
+	}
+
+	writeHeader(w, "antman_pool_lane_started_total", "counter", "Jobs picked up by a worker by priority.")
+	for _, lane := range sortedKeys(stats.Lanes) {
+		fmt.Fprintf(w, "antman_pool_lane_started_total{lane=%q} %d\n", lane, stats.Lanes[lane].Started)
+	}
+
+	writeHeader(w, "antman_pool_queue_size", "gauge", "Upper bound of the jobs of each priority waiting for a worker.")
+	fmt.Fprintf(w, "antman_pool_queue_size %d\n", stats.QueueSize)
+
+	writeHeader(w, "antman_pool_queue_wait_seconds", "summary", "Time jobs waited for a worker.")
+	fmt.Fprintf(w, "antman_pool_queue_wait_seconds_sum %s\n", formatFloat(stats.WaitSeconds))
+	fmt.Fprintf(w, "antman_pool_queue_wait_seconds_count %d\n", stats.Started)
+
+	writeHeader(w, "antman_pool_rejections_total", "counter", "Jobs rejected to shed load.")
+	fmt.Fprintf(w, "antman_pool_rejections_total{reason=\"queue_full\"} %d\n", stats.Rejected)
+	fmt.Fprintf(w, "antman_pool_rejections_total{reason=\"timeout\"} %d\n", stats.TimedOut)
+
+	writeHeader(w, "antman_pool_memory_budget_bytes", "gauge", "Memory jobs may reserve at once, zero when unbounded.")
+	fmt.Fprintf(w, "antman_pool_memory_budget_bytes %d\n", stats.MemoryBudget)
+
+	writeHeader(w, "antman_pool_memory_reserved_bytes", "gauge", "Memory reserved by jobs in progress.")
+	fmt.Fprintf(w, "antman_pool_memory_reserved_bytes %d\n", stats.MemoryReserved)
+
+	writeHeader(w, "antman_pool_memory_waiting", "gauge", "Jobs waiting for memory to be released.")
+	fmt.Fprintf(w, "antman_pool_memory_waiting %d\n", stats.MemoryWaiting)
+}
+
+func writeHeader(w io.Writer, name, kind, help string) {
+	fmt.Fprintf(w, "# HELP %s %s\n", name, help)
+	fmt.Fprintf(w, "# TYPE %s %s\n", name, kind)
+}
+
+func formatFloat(value float64) string {
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
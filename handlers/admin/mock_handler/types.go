@@ -0,0 +1,83 @@
+// Code generated by MockGen. DO NOT EDIT.
+// Source: types.go
+
+// Package mock_handlers is a generated GoMock package.
+package mock_handlers
+
+import (
+	reflect "reflect"
+
+	gin "github.com/gin-gonic/gin"
+	gomock "github.com/golang/mock/gomock"
+)
+
+// MockHandler is a mock of Handler interface.
+type MockHandler struct {
+	ctrl     *gomock.Controller
+	recorder *MockHandlerMockRecorder
+}
+
+// MockHandlerMockRecorder is the mock recorder for MockHandler.
+type MockHandlerMockRecorder struct {
+	mock *MockHandler
+}
+
+// NewMockHandler creates a new mock instance.
+func NewMockHandler(ctrl *gomock.Controller) *MockHandler {
+	mock := &MockHandler{ctrl: ctrl}
+	mock.recorder = &MockHandlerMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockHandler) EXPECT() *MockHandlerMockRecorder {
+	return m.recorder
+}
+
+// HandleMetrics mocks base method.
+func (m *MockHandler) HandleMetrics(c *gin.Context) {
+	m.ctrl.T.Helper()
+	m.ctrl.Call(m, "HandleMetrics", c)
+}
+
+// HandleMetrics indicates an expected call of HandleMetrics.
+func (mr *MockHandlerMockRecorder) HandleMetrics(c interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "HandleMetrics", reflect.TypeOf((*MockHandler)(nil).HandleMetrics), c)
+}
+
+// HandlePurge mocks base method.
+func (m *MockHandler) HandlePurge(c *gin.Context) {
+	m.ctrl.T.Helper()
+	m.ctrl.Call(m, "HandlePurge", c)
+}
+
+// HandlePurge indicates an expected call of HandlePurge.
+func (mr *MockHandlerMockRecorder) HandlePurge(c interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "HandlePurge", reflect.TypeOf((*MockHandler)(nil).HandlePurge), c)
+}
+
+// HandleStats mocks base method.
+func (m *MockHandler) HandleStats(c *gin.Context) {
+	m.ctrl.T.Helper()
+	m.ctrl.Call(m, "HandleStats", c)
+}
+
+// HandleStats indicates an expected call of HandleStats.
+func (mr *MockHandlerMockRecorder) HandleStats(c interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "HandleStats", reflect.TypeOf((*MockHandler)(nil).HandleStats), c)
+}
+
+// HandleWarm mocks base method.
+func (m *MockHandler) HandleWarm(c *gin.Context) {
+	m.ctrl.T.Helper()
+	m.ctrl.Call(m, "HandleWarm", c)
+}
+
+// HandleWarm indicates an expected call of HandleWarm.
+func (mr *MockHandlerMockRecorder) HandleWarm(c interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "HandleWarm", reflect.TypeOf((*MockHandler)(nil).HandleWarm), c)
+}
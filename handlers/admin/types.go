@@ -0,0 +1,10 @@
+package handlers
+
+import "github.com/gin-gonic/gin"
+
+type Handler interface {
+	HandlePurge(c *gin.Context)
+	HandleWarm(c *gin.Context)
+	HandleStats(c *gin.Context)
+	HandleMetrics(c *gin.Context)
+}
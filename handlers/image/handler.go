@@ -1,28 +1,50 @@
 package handlers
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"math"
 	"net/http"
 	"os"
 	"slices"
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/gin-gonic/gin"
 
+	cacheManager "antman-proxy/managers/cache"
 	imageManager "antman-proxy/managers/image"
 )
 
+const DefaultRetryAfter = time.Second
+
 type Config struct {
-	ImageManager imageManager.Manager
-	WorkerPool   *WorkerPool
+	ImageManager  imageManager.Manager
+	WorkerPool    *WorkerPool
+	Presets       map[string]Preset
+	StrictPresets bool          // Only permit preset based requests to bound the number of cached variants
+	RetryAfter    time.Duration // Suggested to clients whose requests were shed, defaults to DefaultRetryAfter
+	Timeout       time.Duration // Deadline of a request, from queueing to the processed image, zero for none
 }
 
 type ImageHandler struct {
-	imageManager imageManager.Manager
-	mu           sync.RWMutex
-	workerPool   *WorkerPool
+	imageManager  imageManager.Manager
+	mu            sync.RWMutex
+	workerPool    *WorkerPool
+	presets       map[string]Preset
+	strictPresets bool
+	retryAfter    time.Duration
+	timeout       time.Duration
+}
+
+type resizeParams struct {
+	width   int
+	height  int
+	format  string
+	quality int
 }
 
 func NewHandler(cfg *Config) (Handler, error) {
@@ -38,10 +60,22 @@ func NewHandler(cfg *Config) (Handler, error) {
 		return nil, fmt.Errorf("cfg.WorkerPool is nil!")
 	}
 
+	if cfg.StrictPresets && len(cfg.Presets) == 0 {
+		return nil, fmt.Errorf("cfg.StrictPresets requires cfg.Presets!")
+	}
+
+	if cfg.RetryAfter <= 0 {
+		cfg.RetryAfter = DefaultRetryAfter
+	}
+
 	return &ImageHandler{
-		imageManager: cfg.ImageManager,
-		mu:           sync.RWMutex{},
-		workerPool:   cfg.WorkerPool,
+		imageManager:  cfg.ImageManager,
+		mu:            sync.RWMutex{},
+		workerPool:    cfg.WorkerPool,
+		presets:       cfg.Presets,
+		strictPresets: cfg.StrictPresets,
+		retryAfter:    cfg.RetryAfter,
+		timeout:       cfg.Timeout,
 	}, nil
 }
 
@@ -49,88 +83,159 @@ func validFormats() []string {
 	return strings.Split(os.Getenv("VALID_FORMATS"), ",")
 }
 
+// Resolves the transformation parameters of the request, starting from the selected preset (if any) and letting
+// explicit query parameters override it. In strict mode only preset based requests are permitted.
+func (h *ImageHandler) resolveParams(c *gin.Context) (*resizeParams, error) {
+	params := &resizeParams{
+		format:  "jpeg",
+		quality: imageManager.DefaultQualityPercent,
+	}
+
+	name := c.Query("preset")
+	if name == "" && h.strictPresets {
+		return nil, fmt.Errorf("Missing preset parameter")
+	}
+
+	if name != "" {
+		preset, ok := h.presets[name]
+		if !ok {
+			return nil, fmt.Errorf("Unknown preset: %s", name)
+		}
+
+		if h.strictPresets {
+			for _, key := range []string{"width", "height", "quality", "format"} {
+				if _, ok := c.GetQuery(key); ok {
+					return nil, fmt.Errorf("Parameter %s cannot be combined with a preset", key)
+				}
+			}
+		}
+
+		params.width = preset.Width
+		params.height = preset.Height
+		if preset.Format != "" {
+			params.format = preset.Format
+		}
+		if preset.Quality != 0 {
+			params.quality = preset.Quality
+		}
+	}
+
+	if value, ok := c.GetQuery("width"); ok {
+		params.width, _ = strconv.Atoi(value)
+	}
+
+	if value, ok := c.GetQuery("height"); ok {
+		params.height, _ = strconv.Atoi(value)
+	}
+
+	if value, ok := c.GetQuery("format"); ok {
+		params.format = value
+	}
+
+	if value, ok := c.GetQuery("quality"); ok {
+		params.quality, _ = strconv.Atoi(value)
+	}
+
+	return params, nil
+}
+
 func (h *ImageHandler) HandleResize(c *gin.Context) {
-	url := c.Query("url")
+	// src references an image in a named origin (e.g. bucket:products/123.jpg) and path one in the local
+	// filesystem origin, both take precedence over url
+	url := c.Query("src")
+	if url == "" && c.Query("path") != "" {
+		url = imageManager.LocalOrigin + ":" + c.Query("path")
+	}
+	if url == "" {
+		url = c.Query("url")
+	}
 	if url == "" {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Missing URL parameter"})
 		return
 	}
 
-	var path string
-	var err error
-	var resultMu sync.Mutex
+	params, err := h.resolveParams(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	width, height, format, quality := params.width, params.height, params.format, params.quality
 
-	width, _ := strconv.Atoi(c.Query("width"))
-	height, _ := strconv.Atoi(c.Query("height"))
-	format := c.DefaultQuery("format", "jpeg")
-	quality, _ := strconv.Atoi(c.DefaultQuery("quality", fmt.Sprintf("%d", imageManager.DefaultQualityPercent)))
+	// Work for the request stops once the client is gone or its deadline passed
+	ctx := c.Request.Context()
+	if h.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, h.timeout)
+		defer cancel()
+	}
 
-	h.workerPool.Submit(func() {
+	future := h.workerPool.Submit(ctx, func(ctx context.Context) (any, error) {
 		h.mu.RLock()
 		defer h.mu.RUnlock()
 
 		if !h.imageManager.IsURLAllowed(url) {
-			resultMu.Lock()
-			err = fmt.Errorf("Invalid URL domain")
-			resultMu.Unlock()
-			return
+			return nil, fmt.Errorf("Invalid URL domain")
 		}
 
 		if width <= 0 && height <= 0 {
-			err = fmt.Errorf("At least one of width or height must be specified")
-			return
+			return nil, fmt.Errorf("At least one of width or height must be specified")
 		}
 
 		if (width > 0 && width > 2000) || (height > 0 && height > 2000) {
-			err = fmt.Errorf("Dimensions must be in the range 1-2000")
-			return
+			return nil, fmt.Errorf("Dimensions must be in the range 1-2000")
 		}
 
 		if !slices.Contains(validFormats(), format) {
-			err = fmt.Errorf("Format must be one of jpeg, png, webp")
-			return
+			return nil, fmt.Errorf("Format must be one of jpeg, png, webp")
 		}
 
 		if quality < 1 || quality > 100 {
-			err = fmt.Errorf("Quality must be between 1 and 100")
-			return
-		}
-
-		resultMu.Lock()
-		path, err = h.imageManager.ProcessImage(url, width, height, format, quality)
-		if err != nil {
-			err = fmt.Errorf(err.Error())
-			return
+			return nil, fmt.Errorf("Quality must be between 1 and 100")
 		}
-		resultMu.Unlock()
 
-		mimeType := fmt.Sprintf("image/%s", format)
-		if format == "jpeg" {
-			mimeType = "image/jpeg"
-		}
-		c.Header("Content-Type", mimeType)
-
-		fileInfo, err := os.Stat(path)
-		if err != nil {
-			err = fmt.Errorf(err.Error())
-			return
-		}
+		return h.imageManager.ProcessImage(ctx, url, width, height, format, quality)
+	})
 
-		etag := fmt.Sprintf(`W/"%d"`, fileInfo.ModTime().Unix())
-		if c.Request.Header.Get("If-None-Match") == etag {
-			c.JSON(http.StatusNotModified, gin.H{})
-			return
-		}
+	value, err := future.Wait(ctx)
+	if err != nil && c.Request.Context().Err() != nil {
+		// The client is gone, an entry produced in the meantime is released once the job finishes
+		go releaseEntry(future)
+		c.Abort()
+		return
+	}
 
-		c.Header("ETag", etag)
-	})
+	if err != nil && ctx.Err() != nil {
+		go releaseEntry(future)
+		c.JSON(http.StatusGatewayTimeout, gin.H{"error": "Request timed out"})
+		return
+	}
 
-	h.workerPool.Wait()
+	// Load is shed before requests pile up, clients are told when to come back, as they are while shutting down
+	if errors.Is(err, ErrOverloaded) || errors.Is(err, ErrPoolShutdown) {
+		c.Header("Retry-After", strconv.Itoa(int(math.Ceil(h.retryAfter.Seconds()))))
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+		return
+	}
 
 	if err != nil {
 		c.JSON(400, gin.H{"error": err.Error()})
 		return
 	}
 
-	c.File(path)
+	entry := value.(*cacheManager.Entry)
+	defer entry.Close()
+
+	// ServeContent answers conditional and range requests against the content hash and creation time
+	c.Header("ETag", fmt.Sprintf(`"%s"`, entry.Hash))
+	c.Header("Content-Type", entry.ContentType)
+	http.ServeContent(c.Writer, c.Request, "", entry.CreatedAt, entry.Body)
+}
+
+// Closes the entry produced by the job of an abandoned request.
+func releaseEntry(future *Future) {
+	value, err := future.Wait(context.Background())
+	if err == nil {
+		value.(*cacheManager.Entry).Close()
+	}
 }
@@ -1,19 +1,21 @@
 package handlers
 
 import (
+	"bytes"
+	"context"
 	"errors"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
-	"os"
-	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/golang/mock/gomock"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 
+	cacheManager "antman-proxy/managers/cache"
 	imageManager "antman-proxy/managers/image/mock_manager"
 )
 
@@ -66,6 +68,21 @@ func TestImageHandler_NewHandler(t *testing.T) {
 		assert.Contains(t, err.Error(), "cfg.WorkerPool is nil!")
 	})
 
+	t.Run("returns error when strict presets are enabled without presets", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		mockManager := imageManager.NewMockManager(ctrl)
+		handler, err := NewHandler(&Config{
+			ImageManager:  mockManager,
+			WorkerPool:    NewWorkerPool(&WorkerPoolConfig{Workers: testWorkers}),
+			StrictPresets: true,
+		})
+		assert.Error(t, err)
+		assert.Nil(t, handler)
+		assert.Contains(t, err.Error(), "cfg.StrictPresets requires cfg.Presets!")
+	})
+
 	t.Run("successfully creates handler", func(t *testing.T) {
 		ctrl := gomock.NewController(t)
 		defer ctrl.Finish()
@@ -73,7 +90,7 @@ func TestImageHandler_NewHandler(t *testing.T) {
 		mockManager := imageManager.NewMockManager(ctrl)
 		handler, err := NewHandler(&Config{
 			ImageManager: mockManager,
-			WorkerPool:   NewWorkerPool(testWorkers),
+			WorkerPool:   NewWorkerPool(&WorkerPoolConfig{Workers: testWorkers}),
 		})
 
 		assert.NoError(t, err)
@@ -85,7 +102,7 @@ func TestImageHandler_HandleResize(t *testing.T) {
 	ctrl, mockManager, router := setupTest(t)
 	defer ctrl.Finish()
 
-	handler, err := NewHandler(&Config{ImageManager: mockManager, WorkerPool: NewWorkerPool(testWorkers)})
+	handler, err := NewHandler(&Config{ImageManager: mockManager, WorkerPool: NewWorkerPool(&WorkerPoolConfig{Workers: testWorkers})})
 	require.NoError(t, err)
 
 	router.GET("/resize", handler.HandleResize)
@@ -125,13 +142,9 @@ func TestImageHandler_HandleResize_Success(t *testing.T) {
 	ctrl, mockManager, router := setupTest(t)
 	defer ctrl.Finish()
 
-	tempDir := t.TempDir()
-	err := os.WriteFile(filepath.Join(tempDir, "image.jpg"), []byte("image.jpg"), 0777)
-	if err != nil {
-		return
-	}
+	entry := cacheManager.NewEntry([]byte("image.jpg"), "jpeg", time.Now(), testURL, nil)
 
-	handler, err := NewHandler(&Config{ImageManager: mockManager, WorkerPool: NewWorkerPool(testWorkers)})
+	handler, err := NewHandler(&Config{ImageManager: mockManager, WorkerPool: NewWorkerPool(&WorkerPoolConfig{Workers: testWorkers})})
 	require.NoError(t, err)
 
 	router.GET("/resize", handler.HandleResize)
@@ -139,29 +152,96 @@ func TestImageHandler_HandleResize_Success(t *testing.T) {
 	t.Run("successful image processing", func(t *testing.T) {
 		mockManager.EXPECT().IsURLAllowed(testURL).Return(true)
 		mockManager.EXPECT().ProcessImage(
+			gomock.Any(),
 			testURL,
 			testWidth,
 			testHeight,
 			"jpeg",
 			80,
-		).Return(filepath.Join(tempDir, "image.jpg"), nil)
+		).Return(entry, nil)
 
 		w := httptest.NewRecorder()
 		req := httptest.NewRequest("GET", fmt.Sprintf("/resize?url=%s&width=%d&height=%d&format=jpeg&quality=80", testURL, testWidth, testHeight), nil)
 		router.ServeHTTP(w, req)
 
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "image/jpeg", w.Header().Get("Content-Type"))
+		assert.Equal(t, "image.jpg", w.Body.String())
+	})
+
+	t.Run("not modified", func(t *testing.T) {
+		mockManager.EXPECT().IsURLAllowed(testURL).Return(true)
+		mockManager.EXPECT().ProcessImage(gomock.Any(), testURL, testWidth, testHeight, "jpeg", 85).Return(entry, nil)
+
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", fmt.Sprintf("/resize?url=%s&width=%d&height=%d", testURL, testWidth, testHeight), nil)
+		req.Header.Set("If-None-Match", fmt.Sprintf(`"%s"`, entry.Hash))
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusNotModified, w.Code)
+		assert.Empty(t, w.Body.String())
+	})
+
+	t.Run("range request", func(t *testing.T) {
+		mockManager.EXPECT().IsURLAllowed(testURL).Return(true)
+		mockManager.EXPECT().ProcessImage(gomock.Any(), testURL, testWidth, testHeight, "jpeg", 85).Return(entry, nil)
+
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", fmt.Sprintf("/resize?url=%s&width=%d&height=%d", testURL, testWidth, testHeight), nil)
+		req.Header.Set("Range", "bytes=0-4")
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusPartialContent, w.Code)
+		assert.Equal(t, "image", w.Body.String())
+		assert.Equal(t, "bytes 0-4/9", w.Header().Get("Content-Range"))
+	})
+
+	t.Run("successful processing from a named origin", func(t *testing.T) {
+		mockManager.EXPECT().IsURLAllowed("bucket:products/123.jpg").Return(true)
+		mockManager.EXPECT().ProcessImage(
+			gomock.Any(),
+			"bucket:products/123.jpg",
+			testWidth,
+			testHeight,
+			"jpeg",
+			85,
+		).Return(entry, nil)
+
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", fmt.Sprintf("/resize?src=bucket:products/123.jpg&width=%d&height=%d", testWidth, testHeight), nil)
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+	})
+
+	t.Run("successful processing from the local filesystem", func(t *testing.T) {
+		mockManager.EXPECT().IsURLAllowed("local:products/123.jpg").Return(true)
+		mockManager.EXPECT().ProcessImage(
+			gomock.Any(),
+			"local:products/123.jpg",
+			testWidth,
+			testHeight,
+			"jpeg",
+			85,
+		).Return(entry, nil)
+
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", fmt.Sprintf("/resize?path=products/123.jpg&width=%d&height=%d", testWidth, testHeight), nil)
+		router.ServeHTTP(w, req)
+
 		assert.Equal(t, http.StatusOK, w.Code)
 	})
 
 	t.Run("successful processing with default parameters", func(t *testing.T) {
 		mockManager.EXPECT().IsURLAllowed(testURL).Return(true)
 		mockManager.EXPECT().ProcessImage(
+			gomock.Any(),
 			testURL,
 			testWidth,
 			testHeight,
 			"jpeg", // default format
 			85,     // default quality
-		).Return(filepath.Join(tempDir, "image.jpg"), nil)
+		).Return(entry, nil)
 
 		w := httptest.NewRecorder()
 		req := httptest.NewRequest("GET", fmt.Sprintf("/resize?url=%s&width=%d&height=%d", testURL, testWidth, testHeight), nil)
@@ -175,7 +255,7 @@ func TestImageHandler_HandleResize_ProcessingError(t *testing.T) {
 	ctrl, mockManager, router := setupTest(t)
 	defer ctrl.Finish()
 
-	handler, err := NewHandler(&Config{ImageManager: mockManager, WorkerPool: NewWorkerPool(testWorkers)})
+	handler, err := NewHandler(&Config{ImageManager: mockManager, WorkerPool: NewWorkerPool(&WorkerPoolConfig{Workers: testWorkers})})
 	require.NoError(t, err)
 
 	router.GET("/resize", handler.HandleResize)
@@ -183,12 +263,13 @@ func TestImageHandler_HandleResize_ProcessingError(t *testing.T) {
 	t.Run("processing error", func(t *testing.T) {
 		mockManager.EXPECT().IsURLAllowed(testURL).Return(true)
 		mockManager.EXPECT().ProcessImage(
+			gomock.Any(),
 			testURL,
 			testWidth,
 			testHeight,
 			"webp",
 			85,
-		).Return("", errors.New("processing failed"))
+		).Return(nil, errors.New("processing failed"))
 
 		w := httptest.NewRecorder()
 		req := httptest.NewRequest("GET", fmt.Sprintf("/resize?url=%s&width=%d&height=%d&format=%s", testURL, testWidth, testHeight, "webp"), nil)
@@ -198,3 +279,214 @@ func TestImageHandler_HandleResize_ProcessingError(t *testing.T) {
 		assert.Contains(t, w.Body.String(), "processing failed")
 	})
 }
+
+// Reports when the entry reading from it is closed
+type closeNotifier struct {
+	*bytes.Reader
+	closed chan struct{}
+}
+
+func (r *closeNotifier) Close() error {
+	close(r.closed)
+	return nil
+}
+
+func TestImageHandler_HandleResize_Abandoned(t *testing.T) {
+	ctrl, mockManager, router := setupTest(t)
+	defer ctrl.Finish()
+
+	handler, err := NewHandler(&Config{ImageManager: mockManager, WorkerPool: NewWorkerPool(&WorkerPoolConfig{Workers: testWorkers})})
+	require.NoError(t, err)
+
+	router.GET("/resize", handler.HandleResize)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	release := make(chan struct{})
+	body := &closeNotifier{Reader: bytes.NewReader([]byte("image.jpg")), closed: make(chan struct{})}
+
+	mockManager.EXPECT().IsURLAllowed(testURL).Return(true)
+	mockManager.EXPECT().ProcessImage(gomock.Any(), testURL, testWidth, testHeight, "jpeg", 85).DoAndReturn(
+		func(context.Context, string, int, int, string, int) (*cacheManager.Entry, error) {
+			cancel()
+			<-release
+			return &cacheManager.Entry{Body: body, Size: 9, ContentType: "image/jpeg"}, nil
+		},
+	)
+
+	// The handler returns as soon as the client is gone instead of waiting for the job
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", fmt.Sprintf("/resize?url=%s&width=%d&height=%d", testURL, testWidth, testHeight), nil)
+	router.ServeHTTP(w, req.WithContext(ctx))
+	assert.Empty(t, w.Body.String())
+
+	// The entry produced afterwards is released
+	close(release)
+	select {
+	case <-body.closed:
+	case <-time.After(time.Second):
+		t.Fatal("the entry of the abandoned request wasn't closed")
+	}
+}
+
+func TestImageHandler_HandleResize_Timeout(t *testing.T) {
+	ctrl, mockManager, router := setupTest(t)
+	defer ctrl.Finish()
+
+	handler, err := NewHandler(&Config{
+		ImageManager: mockManager,
+		WorkerPool:   NewWorkerPool(&WorkerPoolConfig{Workers: testWorkers}),
+		Timeout:      50 * time.Millisecond,
+	})
+	require.NoError(t, err)
+
+	router.GET("/resize", handler.HandleResize)
+
+	// Processing sees the deadline of the request and gives up once it passes
+	mockManager.EXPECT().IsURLAllowed(testURL).Return(true)
+	mockManager.EXPECT().ProcessImage(gomock.Any(), testURL, testWidth, testHeight, "jpeg", 85).DoAndReturn(
+		func(ctx context.Context, _ string, _ int, _ int, _ string, _ int) (*cacheManager.Entry, error) {
+			<-ctx.Done()
+			return nil, ctx.Err()
+		},
+	)
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", fmt.Sprintf("/resize?url=%s&width=%d&height=%d", testURL, testWidth, testHeight), nil)
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusGatewayTimeout, w.Code)
+	assert.Contains(t, w.Body.String(), "Request timed out")
+}
+
+func TestImageHandler_HandleResize_Overloaded(t *testing.T) {
+	ctrl, mockManager, router := setupTest(t)
+	defer ctrl.Finish()
+
+	pool := NewWorkerPool(&WorkerPoolConfig{Workers: 1, QueueSize: 1})
+	defer pool.Shutdown(context.Background())
+
+	handler, err := NewHandler(&Config{ImageManager: mockManager, WorkerPool: pool, RetryAfter: 1500 * time.Millisecond})
+	require.NoError(t, err)
+
+	router.GET("/resize", handler.HandleResize)
+
+	// Keep the worker busy and the queue full
+	release := make(chan struct{})
+	defer close(release)
+	started := make(chan struct{})
+	pool.Submit(context.Background(), func(ctx context.Context) (any, error) {
+		close(started)
+		<-release
+		return nil, nil
+	})
+	<-started
+	pool.Submit(context.Background(), func(ctx context.Context) (any, error) { return nil, nil })
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", fmt.Sprintf("/resize?url=%s&width=%d&height=%d", testURL, testWidth, testHeight), nil)
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Equal(t, "2", w.Header().Get("Retry-After"))
+	assert.Contains(t, w.Body.String(), "queue is full")
+}
+
+func TestImageHandler_HandleResize_Shutdown(t *testing.T) {
+	ctrl, mockManager, router := setupTest(t)
+	defer ctrl.Finish()
+
+	pool := NewWorkerPool(&WorkerPoolConfig{Workers: 1})
+	require.NoError(t, pool.Shutdown(context.Background()))
+
+	handler, err := NewHandler(&Config{ImageManager: mockManager, WorkerPool: pool})
+	require.NoError(t, err)
+
+	router.GET("/resize", handler.HandleResize)
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", fmt.Sprintf("/resize?url=%s&width=%d&height=%d", testURL, testWidth, testHeight), nil)
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Equal(t, "1", w.Header().Get("Retry-After"))
+}
+
+func TestImageHandler_HandleResize_Presets(t *testing.T) {
+	ctrl, mockManager, router := setupTest(t)
+	defer ctrl.Finish()
+
+	entry := cacheManager.NewEntry([]byte("image.webp"), "webp", time.Now(), testURL, nil)
+
+	presets := map[string]Preset{
+		"thumb": {Width: 320, Height: 320, Quality: 70, Format: "webp"},
+	}
+
+	handler, err := NewHandler(&Config{ImageManager: mockManager, WorkerPool: NewWorkerPool(&WorkerPoolConfig{Workers: testWorkers}), Presets: presets})
+	require.NoError(t, err)
+
+	strictHandler, err := NewHandler(&Config{ImageManager: mockManager, WorkerPool: NewWorkerPool(&WorkerPoolConfig{Workers: testWorkers}), Presets: presets, StrictPresets: true})
+	require.NoError(t, err)
+
+	router.GET("/resize", handler.HandleResize)
+	router.GET("/strict/resize", strictHandler.HandleResize)
+
+	t.Run("preset parameters are applied", func(t *testing.T) {
+		mockManager.EXPECT().IsURLAllowed(testURL).Return(true)
+		mockManager.EXPECT().ProcessImage(gomock.Any(), testURL, 320, 320, "webp", 70).Return(entry, nil)
+
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", fmt.Sprintf("/resize?url=%s&preset=thumb", testURL), nil)
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+	})
+
+	t.Run("explicit parameters override preset", func(t *testing.T) {
+		mockManager.EXPECT().IsURLAllowed(testURL).Return(true)
+		mockManager.EXPECT().ProcessImage(gomock.Any(), testURL, 320, 320, "webp", 90).Return(entry, nil)
+
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", fmt.Sprintf("/resize?url=%s&preset=thumb&quality=90", testURL), nil)
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+	})
+
+	t.Run("unknown preset", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", fmt.Sprintf("/resize?url=%s&preset=huge", testURL), nil)
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		assert.Contains(t, w.Body.String(), "Unknown preset: huge")
+	})
+
+	t.Run("strict mode requires a preset", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", fmt.Sprintf("/strict/resize?url=%s&width=%d", testURL, testWidth), nil)
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		assert.Contains(t, w.Body.String(), "Missing preset parameter")
+	})
+
+	t.Run("strict mode rejects overrides", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", fmt.Sprintf("/strict/resize?url=%s&preset=thumb&width=%d", testURL, testWidth), nil)
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		assert.Contains(t, w.Body.String(), "Parameter width cannot be combined with a preset")
+	})
+
+	t.Run("strict mode with preset", func(t *testing.T) {
+		mockManager.EXPECT().IsURLAllowed(testURL).Return(true)
+		mockManager.EXPECT().ProcessImage(gomock.Any(), testURL, 320, 320, "webp", 70).Return(entry, nil)
+
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", fmt.Sprintf("/strict/resize?url=%s&preset=thumb", testURL), nil)
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+	})
+}
@@ -0,0 +1,36 @@
+package handlers
+
+import (
+	"context"
+
+	cacheManager "antman-proxy/managers/cache"
+	imageManager "antman-proxy/managers/image"
+)
+
+// PooledManager processes images on a worker pool with a priority, so batch work such as warming shares the workers
+// with the resize endpoint instead of competing with it.
+type PooledManager struct {
+	imageManager.Manager
+	pool     *WorkerPool
+	priority Priority
+}
+
+func NewPooledManager(manager imageManager.Manager, pool *WorkerPool, priority Priority) *PooledManager {
+	return &PooledManager{Manager: manager, pool: pool, priority: priority}
+}
+
+// ProcessImage waits for a worker of the pool to process the image.
+func (m *PooledManager) ProcessImage(ctx context.Context, imageURL string, width, height int, format string, quality int) (*cacheManager.Entry, error) {
+	future := m.pool.SubmitPriority(ctx, m.priority, func(ctx context.Context) (any, error) {
+		return m.Manager.ProcessImage(ctx, imageURL, width, height, format, quality)
+	})
+
+	value, err := future.Wait(ctx)
+	if err != nil {
+		if ctx.Err() != nil {
+			go releaseEntry(future)
+		}
+		return nil, err
+	}
+	return value.(*cacheManager.Entry), nil
+}
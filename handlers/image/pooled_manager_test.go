@@ -0,0 +1,49 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	cacheManager "antman-proxy/managers/cache"
+)
+
+func TestPooledManager_ProcessImage(t *testing.T) {
+	ctrl, mockManager, _ := setupTest(t)
+	defer ctrl.Finish()
+
+	pool := NewWorkerPool(&WorkerPoolConfig{Workers: testWorkers})
+	defer pool.Shutdown(context.Background())
+
+	manager := NewPooledManager(mockManager, pool, PriorityBackground)
+
+	t.Run("processes on the pool", func(t *testing.T) {
+		entry := &cacheManager.Entry{Body: bytes.NewReader([]byte("image.webp"))}
+		mockManager.EXPECT().ProcessImage(gomock.Any(), testURL, 320, 320, "webp", 70).Return(entry, nil)
+
+		result, err := manager.ProcessImage(context.Background(), testURL, 320, 320, "webp", 70)
+		require.NoError(t, err)
+		data, err := io.ReadAll(result.Body)
+		require.NoError(t, err)
+		assert.Equal(t, []byte("image.webp"), data)
+		assert.Equal(t, int64(1), pool.Stats().Lanes["background"].Started)
+	})
+
+	t.Run("returns processing errors", func(t *testing.T) {
+		mockManager.EXPECT().ProcessImage(gomock.Any(), testURL, 320, 320, "webp", 70).Return(nil, errors.New("processing failed"))
+
+		_, err := manager.ProcessImage(context.Background(), testURL, 320, 320, "webp", 70)
+		assert.EqualError(t, err, "processing failed")
+	})
+
+	t.Run("other methods are delegated", func(t *testing.T) {
+		mockManager.EXPECT().IsURLAllowed(testURL).Return(true)
+		assert.True(t, manager.IsURLAllowed(testURL))
+	})
+}
@@ -0,0 +1,65 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	imageManager "antman-proxy/managers/image"
+)
+
+// Preset is a named set of transformation parameters selectable with the preset query parameter.
+// Zero values are left unset so that the request defaults apply.
+type Preset struct {
+	Width   int    `json:"width"`
+	Height  int    `json:"height"`
+	Quality int    `json:"quality"`
+	Format  string `json:"format"`
+}
+
+// Variant returns the variant the preset produces when requested on its own.
+func (p Preset) Variant() imageManager.Variant {
+	variant := imageManager.Variant{
+		Width:   p.Width,
+		Height:  p.Height,
+		Format:  p.Format,
+		Quality: p.Quality,
+	}
+
+	if variant.Format == "" {
+		variant.Format = "jpeg"
+	}
+
+	if variant.Quality == 0 {
+		variant.Quality = imageManager.DefaultQualityPercent
+	}
+
+	return variant
+}
+
+// LoadPresets reads a JSON file mapping preset names to their transformation parameters, e.g.
+// {"thumb": {"width": 320, "height": 320, "quality": 70, "format": "webp"}}
+func LoadPresets(path string) (map[string]Preset, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	presets := map[string]Preset{}
+	err = json.Unmarshal(data, &presets)
+	if err != nil {
+		return nil, fmt.Errorf("invalid presets file %s: %v", path, err)
+	}
+
+	for name, preset := range presets {
+		if name == "" {
+			return nil, fmt.Errorf("invalid presets file %s: preset name is blank", path)
+		}
+
+		if preset.Width <= 0 && preset.Height <= 0 {
+			return nil, fmt.Errorf("invalid presets file %s: preset %q must specify a width or height", path, name)
+		}
+	}
+
+	return presets, nil
+}
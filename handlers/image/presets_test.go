@@ -0,0 +1,71 @@
+package handlers
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	imageManager "antman-proxy/managers/image"
+)
+
+func TestLoadPresets(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name          string
+		content       string
+		expected      map[string]Preset
+		expectedError string
+	}{
+		{
+			name:    "valid presets",
+			content: `{"thumb": {"width": 320, "height": 320, "quality": 70, "format": "webp"}, "hero": {"width": 1600}}`,
+			expected: map[string]Preset{
+				"thumb": {Width: 320, Height: 320, Quality: 70, Format: "webp"},
+				"hero":  {Width: 1600},
+			},
+		},
+		{
+			name:          "invalid json",
+			content:       `{"thumb": `,
+			expectedError: "invalid presets file",
+		},
+		{
+			name:          "missing dimensions",
+			content:       `{"thumb": {"quality": 70}}`,
+			expectedError: `preset "thumb" must specify a width or height`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "presets.json")
+			err := os.WriteFile(path, []byte(tt.content), 0644)
+			assert.NoError(t, err)
+
+			presets, err := LoadPresets(path)
+			if tt.expectedError != "" {
+				assert.Error(t, err)
+				assert.Contains(t, err.Error(), tt.expectedError)
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, tt.expected, presets)
+			}
+		})
+	}
+
+	t.Run("missing file", func(t *testing.T) {
+		_, err := LoadPresets(filepath.Join(t.TempDir(), "missing.json"))
+		assert.Error(t, err)
+	})
+}
+
+func TestPreset_Variant(t *testing.T) {
+	t.Parallel()
+
+	// Unset parameters get the request defaults
+	assert.Equal(t, imageManager.Variant{Width: 320, Format: "jpeg", Quality: imageManager.DefaultQualityPercent}, Preset{Width: 320}.Variant())
+	assert.Equal(t, imageManager.Variant{Width: 320, Height: 320, Format: "webp", Quality: 70}, Preset{Width: 320, Height: 320, Quality: 70, Format: "webp"}.Variant())
+}
@@ -0,0 +1,78 @@
+package handlers
+
+import (
+	"runtime/metrics"
+	"time"
+)
+
+const DefaultScaleInterval = time.Second
+
+// Above this CPU saturation more workers would only compete for the CPUs with the running ones.
+const saturatedCPU = 0.9
+
+// Scales the workers every interval until the pool is shut down.
+func (p *WorkerPool) scale(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if !p.resize() {
+			return
+		}
+	}
+}
+
+// Adds workers for the queued tasks while CPUs are left, or stops an idle worker once nothing is queued. Returns false
+// once the pool is shut down.
+func (p *WorkerPool) resize() bool {
+	cpu := p.saturation()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.closed {
+		return false
+	}
+	p.cpu = cpu
+
+	switch {
+	case p.queued > 0 && p.target < p.maxWorkers && cpu < saturatedCPU:
+		p.target += min(p.queued, p.maxWorkers-p.target)
+		// Workers about to stop keep running instead, new ones are started for the rest
+		for p.running < p.target {
+			p.running++
+			p.workers.Add(1)
+			go p.worker()
+		}
+	case p.queued == 0 && p.idle > 0 && p.target > p.minWorkers:
+		p.target--
+		p.ready.Broadcast()
+	}
+	return true
+}
+
+// Returns a func estimating the share of GOMAXPROCS that was busy since its last call. The Go runtime updates its
+// estimate at every garbage collection, the saturation is 0 when none happened.
+func runtimeSaturation() func() float64 {
+	samples := []metrics.Sample{
+		{Name: "/cpu/classes/idle:cpu-seconds"},
+		{Name: "/cpu/classes/total:cpu-seconds"},
+	}
+	var lastIdle, lastTotal float64
+
+	return func() float64 {
+		metrics.Read(samples)
+		if samples[0].Value.Kind() != metrics.KindFloat64 || samples[1].Value.Kind() != metrics.KindFloat64 {
+			return 0
+		}
+
+		idle, total := samples[0].Value.Float64(), samples[1].Value.Float64()
+		idleDelta, totalDelta := idle-lastIdle, total-lastTotal
+		lastIdle, lastTotal = idle, total
+
+		if totalDelta <= 0 {
+			return 0
+		}
+		return 1 - idleDelta/totalDelta
+	}
+}
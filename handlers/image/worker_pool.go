@@ -1,65 +1,563 @@
 package handlers
 
 import (
+	"container/list"
+	"context"
+	"errors"
 	"fmt"
+	"runtime"
 	"sync"
+	"time"
+
+	imageManager "antman-proxy/managers/image"
+)
+
+const (
+	DefaultMaxQueueWait     = 5 * time.Second
+	DefaultInteractiveShare = 4
+)
+
+// Priority selects the lane a job waits in for a worker.
+type Priority int
+
+const (
+	// PriorityInteractive is for jobs a client is waiting for, they are bounded by the max wait of the pool.
+	PriorityInteractive Priority = iota
+	// PriorityBackground is for batch jobs such as warming, they yield to interactive jobs and wait as long as needed,
+	// for room in their queue as well as for a worker.
+	PriorityBackground
+	priorities
+)
+
+func (p Priority) String() string {
+	switch p {
+	case PriorityInteractive:
+		return "interactive"
+	case PriorityBackground:
+		return "background"
+	default:
+		return fmt.Sprintf("Priority(%d)", int(p))
+	}
+}
+
+var (
+	// ErrPoolShutdown is returned for jobs submitted once the pool is shutting down, or still queued at its deadline.
+	ErrPoolShutdown = errors.New("worker pool is shut down")
+	// ErrOverloaded is wrapped by the errors of jobs rejected to shed load, their requests can be retried later.
+	ErrOverloaded   = errors.New("server is overloaded")
+	ErrQueueFull    = fmt.Errorf("%w: queue is full", ErrOverloaded)
+	ErrQueueTimeout = fmt.Errorf("%w: job waited too long in the queue", ErrOverloaded)
 )
 
+// Job is run by a worker of the pool. ctx is the context the job was submitted with.
+type Job func(ctx context.Context) (any, error)
+
+// Future is the result of a single submitted job.
+type Future struct {
+	done  chan struct{}
+	value any
+	err   error
+}
+
+func (f *Future) resolve(value any, err error) {
+	f.value = value
+	f.err = err
+	close(f.done)
+}
+
+// Done is closed once the job has finished, or was abandoned because its context was canceled.
+func (f *Future) Done() <-chan struct{} {
+	return f.done
+}
+
+// Wait returns the result of the job, or the error of ctx if it is canceled first. The job keeps running in that
+// case, its result can still be waited for with another context.
+func (f *Future) Wait(ctx context.Context) (any, error) {
+	select {
+	case <-f.done:
+		return f.value, f.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+type task struct {
+	ctx      context.Context
+	job      Job
+	future   *Future
+	enqueued time.Time
+	lane     *lane
+	element  *list.Element // Position in the queue of its lane, nil once the task left it
+	timer    *time.Timer   // Rejects the task once it waited for too long
+	stop     func() bool   // Stops watching the context of the task
+}
+
+type WorkerPoolConfig struct {
+	Workers      int           // Workers started with the pool, defaults to the number of CPUs
+	QueueSize    int           // Upper bound of the jobs of each priority waiting for a worker, defaults to twice the max workers
+	MaxWait      time.Duration // How long an interactive job may wait for a worker, defaults to DefaultMaxQueueWait, negative for no limit
+	MemoryBudget int64         // Bytes of memory jobs may reserve at once with Reserve, zero for no limit
+	// Interactive jobs started for every background job while both are queued, defaults to DefaultInteractiveShare
+	InteractiveShare int
+	// Enables scaling the workers between MinWorkers, defaulting to 1, and MaxWorkers with the queue depth
+	MinWorkers    int
+	MaxWorkers    int
+	ScaleInterval time.Duration  // How often the workers are scaled, defaults to DefaultScaleInterval
+	Saturation    func() float64 // Optional CPU saturation since the last call, defaults to the estimate of the Go runtime
+}
+
+// PoolStats describes the load of a worker pool. Counters are reset when the process restarts.
+type PoolStats struct {
+	Workers        int                  `json:"workers"`     // Currently running
+	MinWorkers     int                  `json:"min_workers"` // Equal to Workers and MaxWorkers unless the pool scales
+	MaxWorkers     int                  `json:"max_workers"`
+	CPUSaturation  float64              `json:"cpu_saturation"` // Last observed when scaling, between 0 and 1
+	Lanes          map[string]LaneStats `json:"lanes"`
+	QueueDepth     int                  `json:"queue_depth"`
+	QueueSize      int                  `json:"queue_size"`
+	Started        int64                `json:"started"`         // Jobs picked up by a worker
+	WaitSeconds    float64              `json:"wait_seconds"`    // Total time started jobs waited in the queue
+	Rejected       int64                `json:"rejected"`        // Jobs rejected because the queue was full
+	TimedOut       int64                `json:"timed_out"`       // Jobs rejected because they waited longer than the max wait
+	MemoryBudget   int64                `json:"memory_budget"`   // Zero when memory isn't bounded
+	MemoryReserved int64                `json:"memory_reserved"` // Bytes reserved by jobs in progress
+	MemoryWaiting  int                  `json:"memory_waiting"`  // Jobs waiting for memory to be released
+}
+
+// LaneStats describes the load of the jobs of a priority.
+type LaneStats struct {
+	QueueDepth int   `json:"queue_depth"`
+	Started    int64 `json:"started"`
+}
+
+// Jobs of a priority waiting for a worker.
+type lane struct {
+	queue   *list.List
+	maxWait time.Duration
+	weight  int // Share of the workers while other lanes have jobs queued
+	current int // Credit of the lane in the weighted round robin
+	started int64
+	block   bool          // Whether submissions wait for room in a full queue instead of being rejected
+	room    chan struct{} // Closed once a task leaves the queue, created by the first submission waiting for room
+}
+
+// WorkerPool runs jobs on a number of workers, fixed unless it scales with the load. Jobs wait for a free worker in a
+// bounded queue per priority. Interactive jobs are rejected with an error wrapping ErrOverloaded when their queue is
+// full or they waited for too long, background jobs wait for room in their queue instead. Workers pick the next job from the lanes in a weighted round robin, so
+// background jobs make progress without starving interactive ones.
+//
+// A scaling pool adds workers while jobs are queued and the CPUs aren't saturated, and stops idle workers once the
+// queues are empty, one every ScaleInterval.
 type WorkerPool struct {
+	minWorkers int
 	maxWorkers int
-	jobs       chan func()
-	wg         sync.WaitGroup
-	shutdown   chan struct{}
+	target     int // Workers that should be running, the others stop once they finish their job
+	running    int
+	idle       int // Workers waiting for a task
+	saturation func() float64
+	cpu        float64 // Last observed CPU saturation
+	queueSize  int
+	mu         sync.Mutex
+	ready      *sync.Cond // Signaled when a task is queued or the pool shuts down
+	lanes      [priorities]*lane
+	queued     int // Tasks queued in every lane
+	closed     bool
+	workers    sync.WaitGroup
+	drained    chan struct{} // Closed once every worker stopped
+	started    int64
+	waited     time.Duration
+	rejected   int64
+	timedOut   int64
+	budget     int64
+	reserved   int64
+	waiters    *list.List // Reservations waiting for memory, in order
+}
+
+// A reservation waiting for memory.
+type waiter struct {
+	size  int64
+	ready chan struct{} // Closed once the memory is reserved
 }
 
-func NewWorkerPool(maxWorkers int) *WorkerPool {
-	if maxWorkers <= 0 {
-		maxWorkers = 1
+func NewWorkerPool(cfg *WorkerPoolConfig) *WorkerPool {
+	if cfg == nil {
+		cfg = &WorkerPoolConfig{}
+	}
+
+	workers := cfg.Workers
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+
+	// Without bounds to scale within the pool keeps its initial workers
+	minWorkers, maxWorkers := workers, workers
+	if cfg.MaxWorkers > 0 {
+		minWorkers, maxWorkers = max(cfg.MinWorkers, 1), max(cfg.MaxWorkers, cfg.MinWorkers, 1)
+		workers = min(max(workers, minWorkers), maxWorkers)
+	}
+
+	queueSize := cfg.QueueSize
+	if queueSize <= 0 {
+		queueSize = maxWorkers * 2 // Buffer for 2x workers
+	}
+
+	maxWait := cfg.MaxWait
+	if maxWait == 0 {
+		maxWait = DefaultMaxQueueWait
+	}
+
+	interactiveShare := cfg.InteractiveShare
+	if interactiveShare <= 0 {
+		interactiveShare = DefaultInteractiveShare
 	}
 
 	pool := &WorkerPool{
+		minWorkers: minWorkers,
 		maxWorkers: maxWorkers,
-		jobs:       make(chan func(), maxWorkers*2), // Buffer for 2x workers
-		shutdown:   make(chan struct{}),
+		target:     workers,
+		running:    workers,
+		saturation: cfg.Saturation,
+		queueSize:  queueSize,
+		drained:    make(chan struct{}),
+		budget:     cfg.MemoryBudget,
+		waiters:    list.New(),
 	}
+	pool.lanes[PriorityInteractive] = &lane{queue: list.New(), maxWait: maxWait, weight: interactiveShare}
+	pool.lanes[PriorityBackground] = &lane{queue: list.New(), weight: 1, block: true}
+	pool.ready = sync.NewCond(&pool.mu)
 
-	for i := 0; i < maxWorkers; i++ {
+	pool.workers.Add(workers)
+	for i := 0; i < workers; i++ {
 		go pool.worker()
 	}
+	go func() {
+		pool.workers.Wait()
+		close(pool.drained)
+	}()
+
+	if minWorkers < maxWorkers {
+		if pool.saturation == nil {
+			pool.saturation = runtimeSaturation()
+		}
+
+		interval := cfg.ScaleInterval
+		if interval <= 0 {
+			interval = DefaultScaleInterval
+		}
+		go pool.scale(interval)
+	}
 
 	return pool
 }
 
+// Removes the task from the queue, returning false when a worker or another rejection already did. Must be called
+// with the lock held.
+func (p *WorkerPool) dequeue(t *task) bool {
+	if t.element == nil {
+		return false
+	}
+
+	t.lane.queue.Remove(t.element)
+	t.element = nil
+	p.queued--
+	t.lane.wake()
+	if t.timer != nil {
+		t.timer.Stop()
+	}
+	if t.stop != nil {
+		t.stop()
+	}
+	return true
+}
+
+// Rejects a task that is still queued with err.
+func (p *WorkerPool) reject(t *task, err error) {
+	p.mu.Lock()
+	if !p.dequeue(t) {
+		p.mu.Unlock()
+		return
+	}
+	if errors.Is(err, ErrQueueTimeout) {
+		p.timedOut++
+	}
+	p.mu.Unlock()
+
+	t.future.resolve(nil, err)
+}
+
+// Waits for the next task, returning nil once the pool is shut down and its queue is drained, or the worker is no
+// longer needed.
+func (p *WorkerPool) next() *task {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for p.queued == 0 && !p.closed && p.running <= p.target {
+		p.idle++
+		p.ready.Wait()
+		p.idle--
+	}
+	if p.queued == 0 || p.running > p.target {
+		p.running--
+		// The worker may have been woken up for a task, another one picks it up
+		if p.queued > 0 {
+			p.ready.Signal()
+		}
+		return nil
+	}
+
+	l := p.pick()
+	t := l.queue.Front().Value.(*task)
+	p.dequeue(t)
+	l.started++
+	p.started++
+	p.waited += time.Since(t.enqueued)
+	return t
+}
+
+// Wakes up the submissions waiting for room in the queue. Must be called with the lock held.
+func (l *lane) wake() {
+	if l.room != nil {
+		close(l.room)
+		l.room = nil
+	}
+}
+
+// Picks the lane the next task is taken from with a smooth weighted round robin over the lanes with tasks queued.
+// Must be called with the lock held while tasks are queued.
+func (p *WorkerPool) pick() *lane {
+	var picked *lane
+	total := 0
+	for _, l := range p.lanes {
+		if l.queue.Len() == 0 {
+			continue
+		}
+		l.current += l.weight
+		total += l.weight
+		if picked == nil || l.current > picked.current {
+			picked = l
+		}
+	}
+	picked.current -= total
+	return picked
+}
+
 func (p *WorkerPool) worker() {
+	defer p.workers.Done()
+
 	for {
-		select {
-		case job := <-p.jobs:
-			// Recover from panics in job execution
-			func() {
-				defer func() {
-					if r := recover(); r != nil {
-						fmt.Printf("Recovered from panic in worker: %v\n", r)
-					}
-					p.wg.Done()
-				}()
-				job()
-			}()
-		case <-p.shutdown:
+		t := p.next()
+		if t == nil {
 			return
 		}
+		p.run(t)
 	}
 }
 
-func (p *WorkerPool) Submit(job func()) {
-	p.wg.Add(1)
-	p.jobs <- job
+func (p *WorkerPool) run(t *task) {
+	// Jobs whose caller is gone while they were queued aren't worth running anymore
+	if err := t.ctx.Err(); err != nil {
+		t.future.resolve(nil, err)
+		return
+	}
+
+	var value any
+	var err error
+
+	// Recover from panics in job execution, the submitter gets an error instead
+	defer func() {
+		if r := recover(); r != nil {
+			fmt.Printf("Recovered from panic in worker: %v\n", r)
+			value, err = nil, fmt.Errorf("job panicked: %v", r)
+		}
+		t.future.resolve(value, err)
+	}()
+
+	value, err = t.job(t.ctx)
+}
+
+// Submit queues the job as an interactive one, see SubmitPriority.
+func (p *WorkerPool) Submit(ctx context.Context, job Job) *Future {
+	return p.SubmitPriority(ctx, PriorityInteractive, job)
 }
 
-func (p *WorkerPool) Wait() {
-	p.wg.Wait()
+// SubmitPriority queues the job in the lane of the priority and returns its future. Interactive jobs are queued right
+// away, their future resolves with ErrQueueFull when the queue of the lane is full or ErrQueueTimeout when no worker
+// picked them up in time. Background jobs block until their queue has room instead. The future also resolves with the
+// error of ctx if it is canceled before the job runs, or ErrPoolShutdown once the pool is shut down.
+func (p *WorkerPool) SubmitPriority(ctx context.Context, priority Priority, job Job) *Future {
+	future := &Future{done: make(chan struct{})}
+	if priority < 0 || priority >= priorities {
+		future.resolve(nil, fmt.Errorf("unknown priority: %s", priority))
+		return future
+	}
+
+	l := p.lanes[priority]
+	t := &task{ctx: ctx, job: job, future: future, enqueued: time.Now(), lane: l}
+
+	p.mu.Lock()
+	for l.block && !p.closed && ctx.Err() == nil && l.queue.Len() >= p.queueSize {
+		if l.room == nil {
+			l.room = make(chan struct{})
+		}
+		room := l.room
+		p.mu.Unlock()
+
+		select {
+		case <-room:
+		case <-ctx.Done():
+		}
+		p.mu.Lock()
+	}
+
+	switch {
+	case p.closed:
+		p.mu.Unlock()
+		future.resolve(nil, ErrPoolShutdown)
+		return future
+	case ctx.Err() != nil:
+		p.mu.Unlock()
+		future.resolve(nil, ctx.Err())
+		return future
+	case l.queue.Len() >= p.queueSize:
+		p.rejected++
+		p.mu.Unlock()
+		future.resolve(nil, ErrQueueFull)
+		return future
+	}
+
+	t.element = l.queue.PushBack(t)
+	p.queued++
+	if l.maxWait > 0 {
+		t.timer = time.AfterFunc(l.maxWait, func() { p.reject(t, ErrQueueTimeout) })
+	}
+	t.stop = context.AfterFunc(ctx, func() { p.reject(t, ctx.Err()) })
+	p.ready.Signal()
+	p.mu.Unlock()
+
+	return future
 }
 
-func (p *WorkerPool) Shutdown() {
-	close(p.shutdown)
-	close(p.jobs)
+// Reserve blocks until size bytes of the memory budget are available, or ctx is done. Jobs reserve the memory they
+// are about to allocate so the pool admits them by their size rather than their number. release must be called once
+// the memory is freed.
+func (p *WorkerPool) Reserve(ctx context.Context, size int64) (release func(), err error) {
+	if p.budget <= 0 {
+		return func() {}, nil
+	}
+	if size > p.budget {
+		return nil, fmt.Errorf("%w: %d bytes exceed the budget of %d", imageManager.ErrTooMuchMemory, size, p.budget)
+	}
+
+	release = func() {
+		p.mu.Lock()
+		p.reserved -= size
+		p.grant()
+		p.mu.Unlock()
+	}
+
+	p.mu.Lock()
+	// Reservations are granted in order, a large one isn't starved by smaller ones getting ahead
+	if p.waiters.Len() == 0 && p.reserved+size <= p.budget {
+		p.reserved += size
+		p.mu.Unlock()
+		return release, nil
+	}
+	w := &waiter{size: size, ready: make(chan struct{})}
+	element := p.waiters.PushBack(w)
+	p.mu.Unlock()
+
+	select {
+	case <-w.ready:
+		return release, nil
+	case <-ctx.Done():
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	select {
+	case <-w.ready:
+		// Granted while giving up
+		p.reserved -= size
+	default:
+		p.waiters.Remove(element)
+	}
+	// Waiters behind this one may fit now
+	p.grant()
+	return nil, ctx.Err()
+}
+
+// Grants the memory to the waiters that fit, in order. Must be called with the lock held.
+func (p *WorkerPool) grant() {
+	for element := p.waiters.Front(); element != nil; element = p.waiters.Front() {
+		w := element.Value.(*waiter)
+		if p.reserved+w.size > p.budget {
+			return
+		}
+		p.waiters.Remove(element)
+		p.reserved += w.size
+		close(w.ready)
+	}
+}
+
+// Stats returns the current load of the pool.
+func (p *WorkerPool) Stats() PoolStats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	lanes := map[string]LaneStats{}
+	for priority, l := range p.lanes {
+		lanes[Priority(priority).String()] = LaneStats{QueueDepth: l.queue.Len(), Started: l.started}
+	}
+
+	return PoolStats{
+		Workers:        p.running,
+		MinWorkers:     p.minWorkers,
+		MaxWorkers:     p.maxWorkers,
+		CPUSaturation:  p.cpu,
+		Lanes:          lanes,
+		QueueDepth:     p.queued,
+		QueueSize:      p.queueSize,
+		Started:        p.started,
+		WaitSeconds:    p.waited.Seconds(),
+		Rejected:       p.rejected,
+		TimedOut:       p.timedOut,
+		MemoryBudget:   p.budget,
+		MemoryReserved: p.reserved,
+		MemoryWaiting:  p.waiters.Len(),
+	}
+}
+
+// Shutdown stops accepting jobs, new submissions resolve with ErrPoolShutdown, and waits for the workers to finish
+// the jobs already queued. Once ctx is done the jobs still queued resolve with ErrPoolShutdown and the error of ctx is
+// returned, jobs being run are left to finish in the background. Shutdown can be called several times.
+func (p *WorkerPool) Shutdown(ctx context.Context) error {
+	p.mu.Lock()
+	p.closed = true
+	p.ready.Broadcast()
+	for _, l := range p.lanes {
+		l.wake()
+	}
+	p.mu.Unlock()
+
+	select {
+	case <-p.drained:
+		return nil
+	case <-ctx.Done():
+	}
+
+	p.mu.Lock()
+	var abandoned []*task
+	for _, l := range p.lanes {
+		for element := l.queue.Front(); element != nil; element = l.queue.Front() {
+			t := element.Value.(*task)
+			p.dequeue(t)
+			abandoned = append(abandoned, t)
+		}
+	}
+	p.mu.Unlock()
+
+	for _, t := range abandoned {
+		t.future.resolve(nil, ErrPoolShutdown)
+	}
+	return ctx.Err()
 }
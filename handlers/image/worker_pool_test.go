@@ -1,35 +1,115 @@
 package handlers
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime"
 	"sync"
 	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	imageManager "antman-proxy/managers/image"
 )
 
+// Waits for every future, failing the test on errors
+func waitAll(t testing.TB, futures []*Future) []any {
+	values := make([]any, len(futures))
+	for i, future := range futures {
+		value, err := future.Wait(context.Background())
+		require.NoError(t, err)
+		values[i] = value
+	}
+	return values
+}
+
 func TestWorkerPool_Behavior(t *testing.T) {
 	t.Parallel()
 
 	t.Run("basic worker pool functionality", func(t *testing.T) {
-		pool := NewWorkerPool(5)
+		pool := NewWorkerPool(&WorkerPoolConfig{Workers: 5})
+		defer pool.Shutdown(context.Background())
 		var counter int32
 
 		// Submit 10 jobs
+		var futures []*Future
 		for i := 0; i < 10; i++ {
-			pool.Submit(func() {
+			futures = append(futures, pool.Submit(context.Background(), func(ctx context.Context) (any, error) {
 				atomic.AddInt32(&counter, 1)
 				time.Sleep(10 * time.Millisecond) // Simulate work
-			})
+				return nil, nil
+			}))
 		}
 
-		pool.Wait()
+		waitAll(t, futures)
 		assert.Equal(t, int32(10), counter, "All jobs should be completed")
 	})
 
+	t.Run("results belong to their job", func(t *testing.T) {
+		pool := NewWorkerPool(&WorkerPoolConfig{Workers: 3, QueueSize: 10})
+		defer pool.Shutdown(context.Background())
+
+		var futures []*Future
+		for i := 0; i < 10; i++ {
+			i := i
+			futures = append(futures, pool.Submit(context.Background(), func(ctx context.Context) (any, error) {
+				if i%2 == 0 {
+					return nil, fmt.Errorf("job %d failed", i)
+				}
+				return i * i, nil
+			}))
+		}
+
+		for i, future := range futures {
+			value, err := future.Wait(context.Background())
+			if i%2 == 0 {
+				assert.EqualError(t, err, fmt.Sprintf("job %d failed", i))
+				continue
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, i*i, value)
+		}
+	})
+
+	t.Run("fast jobs don't wait for slow ones", func(t *testing.T) {
+		pool := NewWorkerPool(&WorkerPoolConfig{Workers: 2})
+		defer pool.Shutdown(context.Background())
+
+		release := make(chan struct{})
+		slow := pool.Submit(context.Background(), func(ctx context.Context) (any, error) {
+			<-release
+			return "slow", nil
+		})
+		fast := pool.Submit(context.Background(), func(ctx context.Context) (any, error) {
+			return "fast", nil
+		})
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+
+		value, err := fast.Wait(ctx)
+		assert.NoError(t, err, "the fast job should resolve while the slow one is running")
+		assert.Equal(t, "fast", value)
+
+		select {
+		case <-slow.Done():
+			t.Fatal("the slow job shouldn't be done yet")
+		default:
+		}
+
+		close(release)
+		value, err = slow.Wait(context.Background())
+		assert.NoError(t, err)
+		assert.Equal(t, "slow", value)
+	})
+
 	t.Run("concurrent job submission", func(t *testing.T) {
-		pool := NewWorkerPool(3)
+		pool := NewWorkerPool(&WorkerPoolConfig{Workers: 3})
+		defer pool.Shutdown(context.Background())
 		var counter int32
 		var wg sync.WaitGroup
 
@@ -38,32 +118,37 @@ func TestWorkerPool_Behavior(t *testing.T) {
 			wg.Add(1)
 			go func() {
 				defer wg.Done()
-				pool.Submit(func() {
+				future := pool.Submit(context.Background(), func(ctx context.Context) (any, error) {
 					atomic.AddInt32(&counter, 1)
 					time.Sleep(10 * time.Millisecond)
+					return nil, nil
 				})
+				_, err := future.Wait(context.Background())
+				assert.NoError(t, err)
 			}()
 		}
 
 		wg.Wait()
-		pool.Wait()
 		assert.Equal(t, int32(5), counter, "All concurrent jobs should be completed")
 	})
 
 	t.Run("worker pool under heavy load", func(t *testing.T) {
-		pool := NewWorkerPool(2)
-		var counter int32
 		jobCount := 100
+		pool := NewWorkerPool(&WorkerPoolConfig{Workers: 2, QueueSize: jobCount})
+		defer pool.Shutdown(context.Background())
+		var counter int32
 
 		start := time.Now()
+		var futures []*Future
 		for i := 0; i < jobCount; i++ {
-			pool.Submit(func() {
+			futures = append(futures, pool.Submit(context.Background(), func(ctx context.Context) (any, error) {
 				atomic.AddInt32(&counter, 1)
 				time.Sleep(1 * time.Millisecond)
-			})
+				return nil, nil
+			}))
 		}
 
-		pool.Wait()
+		waitAll(t, futures)
 		duration := time.Since(start)
 
 		assert.Equal(t, int32(jobCount), counter, "All jobs should be completed")
@@ -73,48 +158,148 @@ func TestWorkerPool_Behavior(t *testing.T) {
 	})
 
 	t.Run("error handling in jobs", func(t *testing.T) {
-		pool := NewWorkerPool(2)
-		var errorCount int32
+		pool := NewWorkerPool(&WorkerPoolConfig{Workers: 2, QueueSize: 10})
+		defer pool.Shutdown(context.Background())
 		var successCount int32
 
 		// Submit jobs that may panic
+		var futures []*Future
 		for i := 0; i < 10; i++ {
 			i := i // Capture loop variable
-			pool.Submit(func() {
-				defer func() {
-					if r := recover(); r != nil {
-						atomic.AddInt32(&errorCount, 1)
-					}
-				}()
-
+			futures = append(futures, pool.Submit(context.Background(), func(ctx context.Context) (any, error) {
 				if i%2 == 0 {
 					panic("intentional panic")
 				}
 				atomic.AddInt32(&successCount, 1)
-			})
+				return nil, nil
+			}))
 		}
 
-		pool.Wait()
-		assert.Equal(t, int32(5), errorCount, "Half of the jobs should have panicked")
+		errorCount := 0
+		for _, future := range futures {
+			_, err := future.Wait(context.Background())
+			if err != nil {
+				assert.EqualError(t, err, "job panicked: intentional panic")
+				errorCount++
+			}
+		}
+		assert.Equal(t, 5, errorCount, "Half of the jobs should have panicked")
 		assert.Equal(t, int32(5), successCount, "Half of the jobs should have succeeded")
 	})
 
 	t.Run("zero workers", func(t *testing.T) {
 		assert.NotPanicsf(t, func() {
-			NewWorkerPool(0)
+			pool := NewWorkerPool(&WorkerPoolConfig{Workers: 0})
+			defer pool.Shutdown(context.Background())
+			assert.Equal(t, runtime.NumCPU(), pool.Stats().Workers, "Should default to a worker per CPU")
 		}, "Should not panic when creating pool with zero workers")
 	})
 
+	t.Run("canceled context", func(t *testing.T) {
+		pool := NewWorkerPool(&WorkerPoolConfig{Workers: 1})
+		defer pool.Shutdown(context.Background())
+
+		release := make(chan struct{})
+		busy := pool.Submit(context.Background(), func(ctx context.Context) (any, error) {
+			<-release
+			return nil, nil
+		})
+
+		// Queued behind the busy worker until its caller gives up
+		ctx, cancel := context.WithCancel(context.Background())
+		var ran atomic.Bool
+		queued := pool.Submit(ctx, func(ctx context.Context) (any, error) {
+			ran.Store(true)
+			return nil, nil
+		})
+
+		cancel()
+		_, err := queued.Wait(ctx)
+		assert.ErrorIs(t, err, context.Canceled)
+
+		close(release)
+		waitAll(t, []*Future{busy})
+
+		_, err = queued.Wait(context.Background())
+		assert.ErrorIs(t, err, context.Canceled, "jobs canceled while queued should be skipped")
+		assert.False(t, ran.Load())
+
+		_, err = pool.Submit(ctx, func(ctx context.Context) (any, error) { return nil, nil }).Wait(context.Background())
+		assert.ErrorIs(t, err, context.Canceled)
+	})
+
+	t.Run("bounded queue", func(t *testing.T) {
+		pool := NewWorkerPool(&WorkerPoolConfig{Workers: 1, QueueSize: 1})
+		defer pool.Shutdown(context.Background())
+
+		release := make(chan struct{})
+		started := make(chan struct{})
+		busy := pool.Submit(context.Background(), func(ctx context.Context) (any, error) {
+			close(started)
+			<-release
+			return nil, nil
+		})
+		<-started
+
+		queued := pool.Submit(context.Background(), func(ctx context.Context) (any, error) { return nil, nil })
+		assert.Equal(t, 1, pool.Stats().QueueDepth)
+
+		// Rejected right away instead of blocking the caller
+		_, err := pool.Submit(context.Background(), func(ctx context.Context) (any, error) { return nil, nil }).Wait(context.Background())
+		assert.ErrorIs(t, err, ErrQueueFull)
+		assert.ErrorIs(t, err, ErrOverloaded)
+
+		close(release)
+		waitAll(t, []*Future{busy, queued})
+
+		stats := pool.Stats()
+		assert.Equal(t, 0, stats.QueueDepth)
+		assert.Equal(t, 1, stats.QueueSize)
+		assert.Equal(t, int64(2), stats.Started)
+		assert.Equal(t, int64(1), stats.Rejected)
+		assert.Greater(t, stats.WaitSeconds, float64(0))
+	})
+
+	t.Run("max wait", func(t *testing.T) {
+		pool := NewWorkerPool(&WorkerPoolConfig{Workers: 1, MaxWait: 20 * time.Millisecond})
+		defer pool.Shutdown(context.Background())
+
+		release := make(chan struct{})
+		busy := pool.Submit(context.Background(), func(ctx context.Context) (any, error) {
+			<-release
+			return nil, nil
+		})
+
+		var ran atomic.Bool
+		queued := pool.Submit(context.Background(), func(ctx context.Context) (any, error) {
+			ran.Store(true)
+			return nil, nil
+		})
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+
+		_, err := queued.Wait(ctx)
+		assert.ErrorIs(t, err, ErrQueueTimeout, "the job should be rejected while the worker is still busy")
+		assert.Equal(t, 0, pool.Stats().QueueDepth, "rejected jobs should free their slot")
+		assert.Equal(t, int64(1), pool.Stats().TimedOut)
+
+		close(release)
+		waitAll(t, []*Future{busy})
+		assert.False(t, ran.Load())
+	})
+
 	t.Run("worker pool shutdown", func(t *testing.T) {
-		pool := NewWorkerPool(2)
+		pool := NewWorkerPool(&WorkerPoolConfig{Workers: 2})
 		var counter int32
 		completedChan := make(chan struct{})
 
 		// Submit a long-running job
-		pool.Submit(func() {
+		pool.Submit(context.Background(), func(ctx context.Context) (any, error) {
 			atomic.AddInt32(&counter, 1)
 			time.Sleep(100 * time.Millisecond)
 			close(completedChan)
+			return nil, nil
 		})
 
 		// Wait for job completion
@@ -124,23 +309,347 @@ func TestWorkerPool_Behavior(t *testing.T) {
 		case <-time.After(200 * time.Millisecond):
 			t.Fatal("Job didn't complete in time")
 		}
+
+		require.NoError(t, pool.Shutdown(context.Background()))
+		require.NoError(t, pool.Shutdown(context.Background()))
+
+		_, err := pool.Submit(context.Background(), func(ctx context.Context) (any, error) {
+			return nil, nil
+		}).Wait(context.Background())
+		assert.ErrorIs(t, err, ErrPoolShutdown)
+	})
+
+	t.Run("shutdown drains the queue", func(t *testing.T) {
+		pool := NewWorkerPool(&WorkerPoolConfig{Workers: 1, QueueSize: 5})
+		release := make(chan struct{})
+
+		busy := pool.Submit(context.Background(), func(ctx context.Context) (any, error) {
+			<-release
+			return nil, nil
+		})
+		var queued []*Future
+		for i := 0; i < 3; i++ {
+			queued = append(queued, pool.Submit(context.Background(), func(ctx context.Context) (any, error) {
+				return "done", nil
+			}))
+		}
+
+		shutdown := make(chan error)
+		go func() { shutdown <- pool.Shutdown(context.Background()) }()
+
+		// Submissions are rejected as soon as the pool is shutting down
+		assert.Eventually(t, func() bool {
+			_, err := pool.Submit(context.Background(), func(ctx context.Context) (any, error) {
+				return nil, nil
+			}).Wait(context.Background())
+			return errors.Is(err, ErrPoolShutdown)
+		}, time.Second, time.Millisecond)
+
+		close(release)
+		require.NoError(t, <-shutdown)
+
+		// Jobs queued before the shutdown were still run
+		waitAll(t, []*Future{busy})
+		for _, value := range waitAll(t, queued) {
+			assert.Equal(t, "done", value)
+		}
+	})
+
+	t.Run("shutdown deadline", func(t *testing.T) {
+		pool := NewWorkerPool(&WorkerPoolConfig{Workers: 1, QueueSize: 1, MaxWait: -1})
+		release := make(chan struct{})
+		defer close(release)
+
+		pool.Submit(context.Background(), func(ctx context.Context) (any, error) {
+			<-release
+			return nil, nil
+		})
+		assert.Eventually(t, func() bool {
+			return pool.Stats().Started == 1
+		}, time.Second, time.Millisecond)
+		queued := pool.Submit(context.Background(), func(ctx context.Context) (any, error) {
+			return nil, nil
+		})
+
+		// A background job waits for room behind a queued one
+		pool.SubmitPriority(context.Background(), PriorityBackground, func(ctx context.Context) (any, error) {
+			return nil, nil
+		})
+		waiting := make(chan *Future)
+		go func() {
+			waiting <- pool.SubmitPriority(context.Background(), PriorityBackground, func(ctx context.Context) (any, error) {
+				return nil, nil
+			})
+		}()
+
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+
+		// Jobs the workers didn't get to in time are rejected, as are the ones waiting for room
+		assert.ErrorIs(t, pool.Shutdown(ctx), context.DeadlineExceeded)
+		_, err := queued.Wait(context.Background())
+		assert.ErrorIs(t, err, ErrPoolShutdown)
+		_, err = (<-waiting).Wait(context.Background())
+		assert.ErrorIs(t, err, ErrPoolShutdown)
+	})
+
+	t.Run("memory budget", func(t *testing.T) {
+		pool := NewWorkerPool(&WorkerPoolConfig{Workers: 1, MemoryBudget: 100})
+		defer pool.Shutdown(context.Background())
+
+		first, err := pool.Reserve(context.Background(), 60)
+		require.NoError(t, err)
+
+		// Reservations that don't fit wait in order, the smaller one would fit but doesn't get ahead of the larger one
+		granted := make(chan int64, 2)
+		for i, size := range []int64{60, 30} {
+			go func(size int64) {
+				release, err := pool.Reserve(context.Background(), size)
+				if assert.NoError(t, err) {
+					granted <- size
+					release()
+				}
+			}(size)
+			assert.Eventually(t, func() bool {
+				return pool.Stats().MemoryWaiting == i+1
+			}, time.Second, time.Millisecond)
+		}
+		assert.Equal(t, int64(60), pool.Stats().MemoryReserved)
+
+		first()
+		assert.ElementsMatch(t, []int64{60, 30}, []int64{<-granted, <-granted})
+		assert.Eventually(t, func() bool {
+			return pool.Stats().MemoryReserved == 0
+		}, time.Second, time.Millisecond)
+	})
+
+	t.Run("memory reservations", func(t *testing.T) {
+		pool := NewWorkerPool(&WorkerPoolConfig{Workers: 1, MemoryBudget: 100})
+		defer pool.Shutdown(context.Background())
+
+		// Reservations larger than the budget are rejected instead of never fitting
+		_, err := pool.Reserve(context.Background(), 101)
+		assert.ErrorIs(t, err, imageManager.ErrTooMuchMemory)
+		assert.Zero(t, pool.Stats().MemoryReserved)
+
+		release, err := pool.Reserve(context.Background(), 100)
+		require.NoError(t, err)
+		assert.Equal(t, int64(100), pool.Stats().MemoryReserved)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		defer cancel()
+		_, err = pool.Reserve(ctx, 10)
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+		assert.Zero(t, pool.Stats().MemoryWaiting)
+
+		release()
+		assert.Zero(t, pool.Stats().MemoryReserved)
+
+		// Without a budget reservations are granted right away
+		unbounded := NewWorkerPool(&WorkerPoolConfig{Workers: 1})
+		defer unbounded.Shutdown(context.Background())
+		release, err = unbounded.Reserve(context.Background(), 1<<40)
+		require.NoError(t, err)
+		release()
+	})
+
+	t.Run("priority lanes", func(t *testing.T) {
+		pool := NewWorkerPool(&WorkerPoolConfig{Workers: 1, QueueSize: 10, InteractiveShare: 2})
+		defer pool.Shutdown(context.Background())
+
+		// Hold the only worker while both lanes fill up
+		release := make(chan struct{})
+		busy := pool.Submit(context.Background(), func(ctx context.Context) (any, error) {
+			<-release
+			return nil, nil
+		})
+		assert.Eventually(t, func() bool {
+			return pool.Stats().Started == 1
+		}, time.Second, time.Millisecond)
+
+		var mu sync.Mutex
+		var order string
+		record := func(lane string) Job {
+			return func(ctx context.Context) (any, error) {
+				mu.Lock()
+				defer mu.Unlock()
+				order += lane
+				return nil, nil
+			}
+		}
+
+		var futures []*Future
+		for i := 0; i < 3; i++ {
+			futures = append(futures, pool.SubmitPriority(context.Background(), PriorityBackground, record("B")))
+		}
+		for i := 0; i < 6; i++ {
+			futures = append(futures, pool.Submit(context.Background(), record("I")))
+		}
+		assert.Equal(t, 3, pool.Stats().Lanes["background"].QueueDepth)
+		assert.Equal(t, 6, pool.Stats().Lanes["interactive"].QueueDepth)
+
+		close(release)
+		waitAll(t, append(futures, busy))
+
+		// Interactive jobs get two workers for every one of the background jobs queued before them
+		assert.Equal(t, "IBIIBIIBI", order)
+		assert.Equal(t, int64(7), pool.Stats().Lanes["interactive"].Started)
+		assert.Equal(t, int64(3), pool.Stats().Lanes["background"].Started)
+	})
+
+	t.Run("background lane", func(t *testing.T) {
+		pool := NewWorkerPool(&WorkerPoolConfig{Workers: 1, QueueSize: 1, MaxWait: 20 * time.Millisecond})
+		defer pool.Shutdown(context.Background())
+
+		release := make(chan struct{})
+		busy := pool.Submit(context.Background(), func(ctx context.Context) (any, error) {
+			<-release
+			return nil, nil
+		})
+		assert.Eventually(t, func() bool {
+			return pool.Stats().Started == 1
+		}, time.Second, time.Millisecond)
+
+		// Lanes are bounded separately, background jobs don't fill the queue of interactive ones
+		background := pool.SubmitPriority(context.Background(), PriorityBackground, func(ctx context.Context) (any, error) {
+			return "background", nil
+		})
+
+		// A full background queue makes submissions wait for room instead of rejecting them
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		defer cancel()
+		_, err := pool.SubmitPriority(ctx, PriorityBackground, func(ctx context.Context) (any, error) {
+			return nil, nil
+		}).Wait(context.Background())
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+		assert.Zero(t, pool.Stats().Rejected)
+
+		waiting := make(chan *Future)
+		go func() {
+			waiting <- pool.SubmitPriority(context.Background(), PriorityBackground, func(ctx context.Context) (any, error) {
+				return "waited", nil
+			})
+		}()
+
+		interactive := pool.Submit(context.Background(), func(ctx context.Context) (any, error) {
+			return nil, nil
+		})
+
+		// Only interactive jobs are bounded by the max wait
+		_, err = interactive.Wait(context.Background())
+		assert.ErrorIs(t, err, ErrQueueTimeout)
+
+		close(release)
+		values := waitAll(t, []*Future{busy, background, <-waiting})
+		assert.Equal(t, "background", values[1])
+		assert.Equal(t, "waited", values[2])
+
+		_, err = pool.SubmitPriority(context.Background(), Priority(7), func(ctx context.Context) (any, error) {
+			return nil, nil
+		}).Wait(context.Background())
+		assert.EqualError(t, err, "unknown priority: Priority(7)")
+	})
+
+	t.Run("scaling", func(t *testing.T) {
+		var saturation atomic.Value
+		saturation.Store(0.5)
+		pool := NewWorkerPool(&WorkerPoolConfig{
+			Workers:       1,
+			MinWorkers:    1,
+			MaxWorkers:    4,
+			QueueSize:     10,
+			ScaleInterval: 5 * time.Millisecond,
+			Saturation:    func() float64 { return saturation.Load().(float64) },
+		})
+		defer pool.Shutdown(context.Background())
+
+		release := make(chan struct{})
+		var running atomic.Int32
+		blocking := func(ctx context.Context) (any, error) {
+			running.Add(1)
+			<-release
+			return nil, nil
+		}
+
+		// Workers are added for the queued jobs, up to the max
+		var futures []*Future
+		for i := 0; i < 6; i++ {
+			futures = append(futures, pool.Submit(context.Background(), blocking))
+		}
+		assert.Eventually(t, func() bool {
+			return running.Load() == 4
+		}, time.Second, time.Millisecond)
+		stats := pool.Stats()
+		assert.Equal(t, 4, stats.Workers)
+		assert.Equal(t, 2, stats.QueueDepth)
+		assert.Equal(t, 0.5, stats.CPUSaturation)
+
+		// Idle workers are stopped once the queue is empty, down to the min
+		close(release)
+		waitAll(t, futures)
+		assert.Eventually(t, func() bool {
+			return pool.Stats().Workers == 1
+		}, time.Second, time.Millisecond)
+
+		// The remaining worker still runs jobs
+		values := waitAll(t, []*Future{pool.Submit(context.Background(), func(ctx context.Context) (any, error) {
+			return "done", nil
+		})})
+		assert.Equal(t, "done", values[0])
+	})
+
+	t.Run("saturated CPUs", func(t *testing.T) {
+		var scaled atomic.Int32
+		pool := NewWorkerPool(&WorkerPoolConfig{
+			Workers:       1,
+			MaxWorkers:    4,
+			QueueSize:     10,
+			ScaleInterval: 5 * time.Millisecond,
+			Saturation: func() float64 {
+				scaled.Add(1)
+				return 0.95
+			},
+		})
+		defer pool.Shutdown(context.Background())
+
+		release := make(chan struct{})
+		var futures []*Future
+		for i := 0; i < 3; i++ {
+			futures = append(futures, pool.Submit(context.Background(), func(ctx context.Context) (any, error) {
+				<-release
+				return nil, nil
+			}))
+		}
+
+		// More workers wouldn't get more CPU, the jobs wait for the one running
+		assert.Eventually(t, func() bool {
+			return scaled.Load() >= 5
+		}, time.Second, time.Millisecond)
+		assert.Equal(t, 1, pool.Stats().Workers)
+		assert.Equal(t, 0.95, pool.Stats().CPUSaturation)
+
+		close(release)
+		waitAll(t, futures)
 	})
 
 	t.Run("sequential job ordering", func(t *testing.T) {
-		pool := NewWorkerPool(1) // Single worker to guarantee order
+		pool := NewWorkerPool(&WorkerPoolConfig{Workers: 1, QueueSize: 5}) // Single worker to guarantee order
+		defer pool.Shutdown(context.Background())
 		var results []int
 		var mu sync.Mutex
 
+		var futures []*Future
 		for i := 0; i < 5; i++ {
 			i := i
-			pool.Submit(func() {
+			futures = append(futures, pool.Submit(context.Background(), func(ctx context.Context) (any, error) {
 				mu.Lock()
 				results = append(results, i)
 				mu.Unlock()
-			})
+				return nil, nil
+			}))
 		}
 
-		pool.Wait()
+		waitAll(t, futures)
 		assert.Len(t, results, 5, "Should complete all jobs")
 		for i := 0; i < len(results)-1; i++ {
 			assert.Less(t, results[i], results[i+1], "Jobs should complete in order")
@@ -148,42 +657,64 @@ func TestWorkerPool_Behavior(t *testing.T) {
 	})
 }
 
+func TestRuntimeSaturation(t *testing.T) {
+	saturation := runtimeSaturation()
+	saturation()
+
+	// The estimate is only updated by garbage collections
+	deadline := time.Now().Add(10 * time.Millisecond)
+	for time.Now().Before(deadline) {
+	}
+	runtime.GC()
+
+	value := saturation()
+	assert.GreaterOrEqual(t, value, 0.0)
+	assert.LessOrEqual(t, value, 1.0)
+}
+
 func BenchmarkWorkerPool(b *testing.B) {
 	b.Run("small jobs", func(b *testing.B) {
-		pool := NewWorkerPool(4)
+		pool := NewWorkerPool(&WorkerPoolConfig{Workers: 4, QueueSize: b.N, MaxWait: -1})
+		defer pool.Shutdown(context.Background())
 		b.ResetTimer()
 
+		futures := make([]*Future, 0, b.N)
 		for i := 0; i < b.N; i++ {
-			pool.Submit(func() {
+			futures = append(futures, pool.Submit(context.Background(), func(ctx context.Context) (any, error) {
 				time.Sleep(1 * time.Microsecond)
-			})
+				return nil, nil
+			}))
 		}
-		pool.Wait()
+		waitAll(b, futures)
 	})
 
 	b.Run("medium jobs", func(b *testing.B) {
-		pool := NewWorkerPool(4)
+		pool := NewWorkerPool(&WorkerPoolConfig{Workers: 4, QueueSize: b.N, MaxWait: -1})
+		defer pool.Shutdown(context.Background())
 		b.ResetTimer()
 
+		futures := make([]*Future, 0, b.N)
 		for i := 0; i < b.N; i++ {
-			pool.Submit(func() {
+			futures = append(futures, pool.Submit(context.Background(), func(ctx context.Context) (any, error) {
 				time.Sleep(1 * time.Millisecond)
-			})
+				return nil, nil
+			}))
 		}
-		pool.Wait()
+		waitAll(b, futures)
 	})
 
 	b.Run("concurrent submission", func(b *testing.B) {
-		pool := NewWorkerPool(4)
+		pool := NewWorkerPool(&WorkerPoolConfig{Workers: 4})
+		defer pool.Shutdown(context.Background())
 		b.ResetTimer()
 
 		b.RunParallel(func(pb *testing.PB) {
 			for pb.Next() {
-				pool.Submit(func() {
+				_, _ = pool.Submit(context.Background(), func(ctx context.Context) (any, error) {
 					time.Sleep(100 * time.Microsecond)
-				})
+					return nil, nil
+				}).Wait(context.Background())
 			}
 		})
-		pool.Wait()
 	})
 }
@@ -2,6 +2,8 @@ package main
 
 import (
 	"context"
+	"fmt"
+	"io"
 	"log"
 	"net/http"
 	"os"
@@ -12,12 +14,17 @@ import (
 	"time"
 
 	"github.com/joho/godotenv"
+	"github.com/redis/go-redis/v9"
 
+	"antman-proxy/clients/s3"
+	adminHandler "antman-proxy/handlers/admin"
 	htmlHandler "antman-proxy/handlers/html"
 	imageHandler "antman-proxy/handlers/image"
 	cacheManager "antman-proxy/managers/cache"
 	imageManager "antman-proxy/managers/image"
+	"antman-proxy/middlewares"
 	"antman-proxy/server"
+	"antman-proxy/sources"
 )
 
 func main() {
@@ -27,6 +34,14 @@ func main() {
 		log.Println("Error loading .env file")
 	}
 
+	if len(os.Args) > 1 && os.Args[1] == "warm" {
+		os.Exit(warm(os.Args[2:]))
+	}
+
+	serve()
+}
+
+func serve() {
 	// Create context that listens for the interrupt signal from the OS.
 	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
 	defer stop()
@@ -36,48 +51,67 @@ func main() {
 		port = "8080"
 	}
 
+	numWorkers, _ := strconv.Atoi(os.Getenv("NUM_WORKERS"))
+	minWorkers, _ := strconv.Atoi(os.Getenv("MIN_WORKERS"))
+	maxWorkers, _ := strconv.Atoi(os.Getenv("MAX_WORKERS"))
+	queueSize, _ := strconv.Atoi(os.Getenv("QUEUE_SIZE"))
+	queueMaxWait, _ := time.ParseDuration(os.Getenv("QUEUE_MAX_WAIT"))
+	memoryBudget, _ := strconv.ParseInt(os.Getenv("MEMORY_BUDGET_BYTES"), 10, 64)
+	workerPool := imageHandler.NewWorkerPool(&imageHandler.WorkerPoolConfig{
+		Workers:      numWorkers,
+		MinWorkers:   minWorkers,
+		MaxWorkers:   maxWorkers,
+		QueueSize:    queueSize,
+		MaxWait:      queueMaxWait,
+		MemoryBudget: memoryBudget,
+	})
+
+	// Images are decoded within the memory budget of the workers
+	app := newComponents(workerPool)
+
 	html, err := htmlHandler.NewHandler()
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	maxAge, err := strconv.ParseInt(os.Getenv("CACHE_MAX_AGE"), 10, 64)
-	if err != nil {
-		log.Fatal(err)
-	}
+	strictPresets, _ := strconv.ParseBool(os.Getenv("STRICT_PRESETS"))
 
-	cache, err := cacheManager.NewManager(&cacheManager.Config{
-		CacheDir: os.Getenv("CACHE_DIR"),
-		MaxAge:   maxAge,
+	requestTimeout, _ := time.ParseDuration(os.Getenv("REQUEST_TIMEOUT"))
+	image, err := imageHandler.NewHandler(&imageHandler.Config{
+		ImageManager:  app.imageManager,
+		WorkerPool:    workerPool,
+		Presets:       app.presets,
+		StrictPresets: strictPresets,
+		Timeout:       requestTimeout,
 	})
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	allowedDomains := strings.Split(os.Getenv("ALLOWED_DOMAINS"), ",")
-	imgManager, err := imageManager.NewManager(&imageManager.Config{
-		AllowedDomains: allowedDomains,
-		CacheManager:   cache,
-	})
-	if err != nil {
-		log.Fatal(err)
+	// The admin endpoints are only served when a token is configured
+	var admin adminHandler.Handler
+	adminToken := os.Getenv("ADMIN_TOKEN")
+	if adminToken != "" {
+		admin, err = newAdminHandler(app, workerPool)
+		if err != nil {
+			log.Fatal(err)
+		}
 	}
 
-	numWorkers, _ := strconv.Atoi(os.Getenv("NUM_WORKERS"))
-	image, err := imageHandler.NewHandler(&imageHandler.Config{
-		ImageManager: imgManager,
-		WorkerPool:   imageHandler.NewWorkerPool(numWorkers),
-	})
+	rateLimitStore, err := newRateLimitStore(os.Getenv("RATE_LIMIT_STORE"))
 	if err != nil {
 		log.Fatal(err)
 	}
 
 	s := server.NewServer(&server.Config{
-		HtmlHandler:  html,
-		ImageHandler: image,
-		CacheManager: cache,
-		ImageManager: imgManager,
-		Port:         port,
+		HtmlHandler:    html,
+		ImageHandler:   image,
+		CacheManager:   app.cache,
+		ImageManager:   app.imageManager,
+		AdminHandler:   admin,
+		AdminToken:     adminToken,
+		RateLimitStore: rateLimitStore,
+		Port:           port,
 	})
 
 	// Initializing the server in a goroutine so that
@@ -100,8 +134,260 @@ func main() {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 	if err := s.Shutdown(ctx); err != nil {
-		log.Fatal("Server forced to shutdown: ", err)
+		// The workers and caches are still shut down below
+		log.Println("Server forced to shutdown: ", err)
+	}
+
+	// Finish the images still queued, abandoned requests may have left some behind. The pool gets its own deadline,
+	// the server may have used up its own waiting for slow requests
+	poolCtx, poolCancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer poolCancel()
+	if err := workerPool.Shutdown(poolCtx); err != nil {
+		log.Println("Worker pool forced to shutdown: ", err)
+	}
+
+	// Stop the caches once no more requests are being served
+	app.close()
+	if closer, ok := rateLimitStore.(io.Closer); ok {
+		if err := closer.Close(); err != nil {
+			log.Println("Error closing rate limit store: ", err)
+		}
 	}
 
 	log.Println("Server exiting")
 }
+
+// Dependencies shared by the server and the warm subcommand, configured through the environment.
+type components struct {
+	cache        cacheManager.Manager
+	backend      cacheManager.Manager
+	sourceCache  cacheManager.Manager
+	imageManager *imageManager.ImageManager
+	presets      map[string]imageHandler.Preset
+}
+
+// Builds the components, memoryBudget is optional and bounds the memory of the images decoded at once.
+func newComponents(memoryBudget imageManager.MemoryBudget) *components {
+	maxAge, err := strconv.ParseInt(os.Getenv("CACHE_MAX_AGE"), 10, 64)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	backend, err := newCacheBackend(os.Getenv("CACHE_BACKEND"), maxAge)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Hot variants are optionally served from memory in front of the shared cache backend
+	cache := backend
+	memoryMaxBytes, _ := strconv.ParseInt(os.Getenv("MEMORY_CACHE_MAX_BYTES"), 10, 64)
+	if memoryMaxBytes > 0 {
+		cache, err = cacheManager.NewMemoryManager(&cacheManager.MemoryConfig{
+			Next:     backend,
+			MaxBytes: memoryMaxBytes,
+			MaxAge:   maxAge,
+		})
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	var origins map[string]sources.Source
+	if originsFile := os.Getenv("ORIGINS_FILE"); originsFile != "" {
+		origins, err = sources.LoadOrigins(originsFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	// Serves images from a mounted directory through the path parameter
+	var local sources.Source
+	if localRoot := os.Getenv("LOCAL_ROOT"); localRoot != "" {
+		local, err = sources.NewFilesystemSource(&sources.FilesystemConfig{
+			Root:     localRoot,
+			Symlinks: sources.SymlinkPolicy(os.Getenv("LOCAL_SYMLINKS")),
+		})
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	var domains map[string]*sources.FetchConfig
+	if fetchConfigFile := os.Getenv("FETCH_CONFIG_FILE"); fetchConfigFile != "" {
+		domains, err = sources.LoadFetchConfigs(fetchConfigFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	// Originals are optionally cached on disk so every size of an image is derived from a single download
+	var sourceCache cacheManager.Manager
+	if sourceCacheDir := os.Getenv("SOURCE_CACHE_DIR"); sourceCacheDir != "" {
+		sourceMaxAge, _ := strconv.ParseInt(os.Getenv("SOURCE_CACHE_MAX_AGE"), 10, 64)
+		sourceMaxBytes, _ := strconv.ParseInt(os.Getenv("SOURCE_CACHE_MAX_BYTES"), 10, 64)
+
+		sourceCache, err = cacheManager.NewManager(&cacheManager.Config{
+			CacheDir: sourceCacheDir,
+			MaxAge:   sourceMaxAge,
+			MaxBytes: sourceMaxBytes,
+		})
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	allowedDomains := strings.Split(os.Getenv("ALLOWED_DOMAINS"), ",")
+	maxPixels, _ := strconv.ParseInt(os.Getenv("MAX_PIXELS"), 10, 64)
+	imgManager, err := imageManager.NewManager(&imageManager.Config{
+		AllowedDomains: allowedDomains,
+		CacheManager:   cache,
+		Origins:        origins,
+		Local:          local,
+		Domains:        domains,
+		SourceCache:    sourceCache,
+		KeySalt:        os.Getenv("CACHE_KEY_SALT"),
+		MaxPixels:      maxPixels,
+		MemoryBudget:   memoryBudget,
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	var presets map[string]imageHandler.Preset
+	if presetsFile := os.Getenv("PRESETS_FILE"); presetsFile != "" {
+		presets, err = imageHandler.LoadPresets(presetsFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	return &components{
+		cache:        cache,
+		backend:      backend,
+		sourceCache:  sourceCache,
+		imageManager: imgManager,
+		presets:      presets,
+	}
+}
+
+// Returns the caches keeping statistics, by name.
+func (c *components) stats() map[string]cacheManager.StatsReporter {
+	caches := map[string]cacheManager.StatsReporter{}
+	// Without a memory tier the cache is the backend itself
+	if reporter, ok := c.cache.(cacheManager.StatsReporter); ok && c.cache != c.backend {
+		caches["memory"] = reporter
+	}
+	if reporter, ok := c.backend.(cacheManager.StatsReporter); ok {
+		caches["variants"] = reporter
+	}
+	if reporter, ok := c.sourceCache.(cacheManager.StatsReporter); ok {
+		caches["sources"] = reporter
+	}
+	return caches
+}
+
+// Creates the admin handler, reporting the statistics of the caches of the components and the load of the workers.
+func newAdminHandler(app *components, workerPool *imageHandler.WorkerPool) (adminHandler.Handler, error) {
+	return adminHandler.NewHandler(&adminHandler.Config{
+		ImageManager: app.imageManager,
+		PurgeWebhook: os.Getenv("CDN_PURGE_WEBHOOK"),
+		WebhookToken: os.Getenv("CDN_PURGE_TOKEN"),
+		Presets:      app.presets,
+		Caches:       app.stats(),
+		WorkerPool:   workerPool,
+	})
+}
+
+// Stops the caches' background goroutines and connections.
+func (c *components) close() {
+	if closer, ok := c.cache.(io.Closer); ok {
+		if err := closer.Close(); err != nil {
+			log.Println("Error closing cache: ", err)
+		}
+	}
+	if closer, ok := c.sourceCache.(io.Closer); ok {
+		if err := closer.Close(); err != nil {
+			log.Println("Error closing source cache: ", err)
+		}
+	}
+}
+
+// Creates the cache backend selected with CACHE_BACKEND. The disk cache is local to each replica, while Redis and
+// S3-compatible storage share processed variants between replicas.
+func newCacheBackend(backend string, maxAge int64) (cacheManager.Manager, error) {
+	switch backend {
+	case "", "disk":
+		cacheMaxBytes, _ := strconv.ParseInt(os.Getenv("CACHE_MAX_BYTES"), 10, 64)
+		cacheMaxEntries, _ := strconv.Atoi(os.Getenv("CACHE_MAX_ENTRIES"))
+
+		janitorInterval, _ := time.ParseDuration(os.Getenv("CACHE_JANITOR_INTERVAL"))
+		janitorBatchSize, _ := strconv.Atoi(os.Getenv("CACHE_JANITOR_BATCH_SIZE"))
+
+		return cacheManager.NewManager(&cacheManager.Config{
+			CacheDir:         os.Getenv("CACHE_DIR"),
+			MaxAge:           maxAge,
+			MaxBytes:         cacheMaxBytes,
+			MaxEntries:       cacheMaxEntries,
+			Eviction:         cacheManager.EvictionPolicy(os.Getenv("CACHE_EVICTION")),
+			JanitorInterval:  janitorInterval,
+			JanitorBatchSize: janitorBatchSize,
+		})
+	case "redis":
+		options, err := redis.ParseURL(os.Getenv("REDIS_URL"))
+		if err != nil {
+			return nil, err
+		}
+
+		return cacheManager.NewRedisManager(&cacheManager.RedisConfig{
+			Client: redis.NewClient(options),
+			Prefix: os.Getenv("REDIS_PREFIX"),
+			MaxAge: maxAge,
+		})
+	case "s3":
+		client, err := s3.NewClient(&s3.Config{
+			Endpoint:  os.Getenv("CACHE_S3_ENDPOINT"),
+			Region:    os.Getenv("CACHE_S3_REGION"),
+			Bucket:    os.Getenv("CACHE_S3_BUCKET"),
+			AccessKey: os.Getenv("CACHE_S3_ACCESS_KEY"),
+			SecretKey: os.Getenv("CACHE_S3_SECRET_KEY"),
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		return cacheManager.NewS3Manager(&cacheManager.S3Config{
+			Client: client,
+			Prefix: os.Getenv("CACHE_S3_PREFIX"),
+			MaxAge: maxAge,
+		})
+	default:
+		return nil, fmt.Errorf("unsupported cache backend: %s", backend)
+	}
+}
+
+// Creates the rate limit store selected with RATE_LIMIT_STORE. The memory store limits clients per replica, while
+// Redis shares the limits between replicas.
+func newRateLimitStore(store string) (middlewares.LimiterStore, error) {
+	switch store {
+	case "", "memory":
+		return middlewares.NewMemoryStore(), nil
+	case "redis":
+		options, err := redis.ParseURL(os.Getenv("REDIS_URL"))
+		if err != nil {
+			return nil, err
+		}
+
+		// Buckets live next to the cached variants when both share the instance
+		prefix := ""
+		if redisPrefix := os.Getenv("REDIS_PREFIX"); redisPrefix != "" {
+			prefix = redisPrefix + "ratelimit:"
+		}
+
+		return middlewares.NewRedisStore(&middlewares.RedisStoreConfig{
+			Client: redis.NewClient(options),
+			Prefix: prefix,
+		})
+	default:
+		return nil, fmt.Errorf("unsupported rate limit store: %s", store)
+	}
+}
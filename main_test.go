@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	imageHandler "antman-proxy/handlers/image"
+	cacheManager "antman-proxy/managers/cache"
+	imageManager "antman-proxy/managers/image"
+)
+
+func newTestComponents(t *testing.T) *components {
+	cache, err := cacheManager.NewManager(&cacheManager.Config{CacheDir: t.TempDir(), MaxAge: 60})
+	require.NoError(t, err)
+	t.Cleanup(func() { cache.Close() })
+
+	manager, err := imageManager.NewManager(&imageManager.Config{AllowedDomains: []string{"imgur.com"}, CacheManager: cache})
+	require.NoError(t, err)
+
+	return &components{cache: cache, backend: cache, imageManager: manager}
+}
+
+func TestNewAdminHandler(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	pool := imageHandler.NewWorkerPool(&imageHandler.WorkerPoolConfig{Workers: 2})
+	defer pool.Shutdown(context.Background())
+
+	admin, err := newAdminHandler(newTestComponents(t), pool)
+	require.NoError(t, err)
+
+	router := gin.New()
+	router.GET("/admin/stats", admin.HandleStats)
+	router.GET("/admin/metrics", admin.HandleMetrics)
+
+	// The caches of the components and the load of the workers are reported
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/admin/stats", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+
+	var stats struct {
+		Caches     map[string]cacheManager.Stats `json:"caches"`
+		WorkerPool *imageHandler.PoolStats       `json:"worker_pool"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &stats))
+	assert.Contains(t, stats.Caches, "variants")
+	assert.NotContains(t, stats.Caches, "memory", "the cache is the backend itself without a memory tier")
+	if assert.NotNil(t, stats.WorkerPool) {
+		assert.Equal(t, 4, stats.WorkerPool.QueueSize)
+	}
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/admin/metrics", nil))
+	assert.Contains(t, w.Body.String(), `antman_cache_entries{cache="variants"} 0`)
+	assert.Contains(t, w.Body.String(), "antman_pool_queue_depth 0")
+	assert.Contains(t, w.Body.String(), `antman_pool_rejections_total{reason="queue_full"} 0`)
+}
+
+func TestNewAdminHandler_WorkerCounts(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	pool := imageHandler.NewWorkerPool(&imageHandler.WorkerPoolConfig{
+		Workers:       2,
+		MinWorkers:    1,
+		MaxWorkers:    4,
+		ScaleInterval: time.Hour,
+	})
+	defer pool.Shutdown(context.Background())
+
+	admin, err := newAdminHandler(newTestComponents(t), pool)
+	require.NoError(t, err)
+
+	router := gin.New()
+	router.GET("/admin/metrics", admin.HandleMetrics)
+
+	// The bounds the workers scale within are exported along with the current count
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/admin/metrics", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	for _, line := range []string{
+		"antman_pool_workers 2",
+		"antman_pool_workers_min 1",
+		"antman_pool_workers_max 4",
+	} {
+		assert.Contains(t, w.Body.String(), line+"\n")
+	}
+}
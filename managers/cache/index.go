@@ -0,0 +1,235 @@
+package managers
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// EvictionPolicy decides which entries are removed first once the cache exceeds its bounds.
+type EvictionPolicy string
+
+const (
+	EvictLRU EvictionPolicy = "lru" // Least recently used
+	EvictLFU EvictionPolicy = "lfu" // Least frequently used, ties broken by recency
+)
+
+type indexEntry struct {
+	path       string
+	size       int64
+	lastAccess time.Time
+	modTime    time.Time // When the entry was stored or last refreshed
+	hits       int64
+	source     string
+}
+
+// Tracks the size, access times and sources of the files in the cache directory.
+type index struct {
+	mu      sync.Mutex
+	entries map[string]*indexEntry
+	sources map[string]map[string]struct{} // Paths of the variants derived from each source
+	size    int64
+}
+
+func newIndex() *index {
+	return &index{
+		entries: map[string]*indexEntry{},
+		sources: map[string]map[string]struct{}{},
+	}
+}
+
+// Must be called with the lock held.
+func (i *index) delete(entry *indexEntry) {
+	i.size -= entry.size
+	delete(i.entries, entry.path)
+
+	if entry.source != "" {
+		delete(i.sources[entry.source], entry.path)
+		if len(i.sources[entry.source]) == 0 {
+			delete(i.sources, entry.source)
+		}
+	}
+}
+
+func (i *index) add(path string, size int64, lastAccess time.Time, source string) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	if entry, ok := i.entries[path]; ok {
+		i.delete(entry)
+	}
+
+	i.entries[path] = &indexEntry{path: path, size: size, lastAccess: lastAccess, modTime: lastAccess, source: source}
+	i.size += size
+
+	if source != "" {
+		if i.sources[source] == nil {
+			i.sources[source] = map[string]struct{}{}
+		}
+		i.sources[source][path] = struct{}{}
+	}
+}
+
+func (i *index) touch(path string) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	if entry, ok := i.entries[path]; ok {
+		entry.lastAccess = time.Now()
+		entry.hits++
+	}
+}
+
+func (i *index) refresh(path string, modTime time.Time) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	if entry, ok := i.entries[path]; ok {
+		entry.modTime = modTime
+	}
+}
+
+func (i *index) remove(path string) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	if entry, ok := i.entries[path]; ok {
+		i.delete(entry)
+	}
+}
+
+// Returns the total size in bytes and the number of entries.
+func (i *index) stats() (int64, int) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	return i.size, len(i.entries)
+}
+
+// Returns the size, per format breakdown and age distribution of the entries, as of now.
+func (i *index) snapshot(now time.Time) Stats {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	stats := newStats()
+	for _, entry := range i.entries {
+		stats.add(formatOf(entry.path), entry.size, now.Sub(entry.modTime))
+	}
+	return stats
+}
+
+// Removes entries from the index in eviction order until it is within the bounds, returning their paths so the
+// caller can delete the files. A bound of 0 is unlimited.
+func (i *index) evict(maxBytes int64, maxEntries int, policy EvictionPolicy) []string {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	if (maxBytes <= 0 || i.size <= maxBytes) && (maxEntries <= 0 || len(i.entries) <= maxEntries) {
+		return nil
+	}
+
+	candidates := make([]*indexEntry, 0, len(i.entries))
+	for _, entry := range i.entries {
+		candidates = append(candidates, entry)
+	}
+
+	sort.Slice(candidates, func(a, b int) bool {
+		if policy == EvictLFU && candidates[a].hits != candidates[b].hits {
+			return candidates[a].hits < candidates[b].hits
+		}
+		return candidates[a].lastAccess.Before(candidates[b].lastAccess)
+	})
+
+	var evicted []string
+	for _, entry := range candidates {
+		if (maxBytes <= 0 || i.size <= maxBytes) && (maxEntries <= 0 || len(i.entries) <= maxEntries) {
+			break
+		}
+
+		i.delete(entry)
+		evicted = append(evicted, entry.path)
+	}
+
+	return evicted
+}
+
+// Rebuilds the index from the files in the cache directory. Access times aren't persisted, so modification times
+// are used instead, sources are read from the sidecar files. Temporary files left behind by interrupted writes and
+// validator sidecar files whose image is gone are removed, which assumes no other process is writing to the directory
+// at startup.
+func (i *index) reconcile(dir string) error {
+	var orphans []string
+
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if d.IsDir() {
+			return nil
+		}
+
+		if strings.HasPrefix(d.Name(), tempPrefix) {
+			orphans = append(orphans, path)
+			return nil
+		}
+
+		if strings.HasSuffix(path, ".meta") {
+			_, err := os.Stat(strings.TrimSuffix(path, ".meta"))
+			if os.IsNotExist(err) {
+				orphans = append(orphans, path)
+			}
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+
+		source := ""
+		if meta := readMetadata(path); meta != nil {
+			source = meta.Source
+		}
+
+		i.add(path, info.Size(), info.ModTime(), source)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, orphan := range orphans {
+		_ = os.Remove(orphan)
+	}
+
+	return nil
+}
+
+// Returns a snapshot of the indexed paths.
+func (i *index) paths() []string {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	paths := make([]string, 0, len(i.entries))
+	for path := range i.entries {
+		paths = append(paths, path)
+	}
+	return paths
+}
+
+// Returns a snapshot of the paths of the variants derived from the source.
+func (i *index) variants(source string) []string {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	paths := make([]string, 0, len(i.sources[source]))
+	for path := range i.sources[source] {
+		paths = append(paths, path)
+	}
+	return paths
+}
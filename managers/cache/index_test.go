@@ -0,0 +1,99 @@
+package managers
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIndex_Evict(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now()
+
+	setup := func() *index {
+		i := newIndex()
+		i.add("a", 10, now.Add(-3*time.Minute), "")
+		i.add("b", 10, now.Add(-2*time.Minute), "")
+		i.add("c", 10, now.Add(-1*time.Minute), "")
+		return i
+	}
+
+	tests := []struct {
+		name       string
+		maxBytes   int64
+		maxEntries int
+		policy     EvictionPolicy
+		touch      []string
+		expected   []string
+	}{
+		{
+			name:     "within bounds",
+			maxBytes: 30,
+			policy:   EvictLRU,
+			expected: nil,
+		},
+		{
+			name:     "lru by size",
+			maxBytes: 15,
+			policy:   EvictLRU,
+			expected: []string{"a", "b"},
+		},
+		{
+			name:       "lru by entry count",
+			maxEntries: 2,
+			policy:     EvictLRU,
+			touch:      []string{"a"},
+			expected:   []string{"b"},
+		},
+		{
+			name:       "lfu",
+			maxEntries: 1,
+			policy:     EvictLFU,
+			touch:      []string{"a", "a", "b"},
+			expected:   []string{"c", "b"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			i := setup()
+			for _, path := range tt.touch {
+				i.touch(path)
+			}
+
+			assert.Equal(t, tt.expected, i.evict(tt.maxBytes, tt.maxEntries, tt.policy))
+
+			size, entries := i.stats()
+			assert.Equal(t, int64(10*(3-len(tt.expected))), size)
+			assert.Equal(t, 3-len(tt.expected), entries)
+		})
+	}
+}
+
+func TestIndex_Reconcile(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "a.jpg"), []byte("aaaa"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "a.jpg.meta"), []byte("{}"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "b.png"), []byte("bb"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "orphan.jpg.meta"), []byte("{}"), 0644))
+
+	i := newIndex()
+	require.NoError(t, i.reconcile(dir))
+
+	size, entries := i.stats()
+	assert.Equal(t, int64(6), size)
+	assert.Equal(t, 2, entries)
+
+	_, err := os.Stat(filepath.Join(dir, "orphan.jpg.meta"))
+	assert.True(t, os.IsNotExist(err), "orphaned sidecar files should be removed")
+
+	_, err = os.Stat(filepath.Join(dir, "a.jpg.meta"))
+	assert.NoError(t, err)
+}
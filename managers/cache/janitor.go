@@ -0,0 +1,87 @@
+package managers
+
+import (
+	"log"
+	"os"
+	"time"
+)
+
+const (
+	DefaultJanitorInterval  = 10 * time.Minute
+	DefaultJanitorBatchSize = 1000
+)
+
+// SweepResult reports what a janitor sweep reclaimed.
+type SweepResult struct {
+	Removed int   `json:"removed"`
+	Bytes   int64 `json:"bytes"`
+}
+
+func (m *CacheManager) isExpired(info os.FileInfo, path string) bool {
+	age := time.Now().Unix() - info.ModTime().Unix()
+	if age <= m.maxAge {
+		return false
+	}
+
+	// Entries that can be revalidated are kept around for a while longer
+	if readValidators(path) != nil {
+		return age > m.maxAge+m.staleMaxAge
+	}
+
+	return true
+}
+
+// Sweep removes expired entries that would otherwise only be deleted when requested again. Entries are checked in
+// batches so a sweep can be interrupted by Close between them.
+func (m *CacheManager) Sweep() SweepResult {
+	result := SweepResult{}
+	paths := m.index.paths()
+
+	for start := 0; start < len(paths); start += m.janitorBatchSize {
+		select {
+		case <-m.done:
+			return result
+		default:
+		}
+
+		end := min(start+m.janitorBatchSize, len(paths))
+		for _, path := range paths[start:end] {
+			info, err := os.Stat(path)
+			if os.IsNotExist(err) {
+				m.index.remove(path)
+				continue
+			}
+			if err != nil || !m.isExpired(info, path) {
+				continue
+			}
+
+			m.remove(path)
+			m.expirations.Add(1)
+			result.Removed++
+			result.Bytes += info.Size()
+		}
+	}
+
+	return result
+}
+
+// Periodically sweeps expired entries until the manager is closed.
+func (m *CacheManager) janitor() {
+	defer m.wg.Done()
+
+	ticker := time.NewTicker(m.janitorInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-m.done:
+			return
+		}
+
+		result := m.Sweep()
+		if result.Removed > 0 {
+			log.Printf("cache janitor: removed %d expired entries, reclaimed %d bytes\n", result.Removed, result.Bytes)
+		}
+	}
+}
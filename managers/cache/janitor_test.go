@@ -0,0 +1,86 @@
+package managers
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCacheManager_Sweep(t *testing.T) {
+	t.Parallel()
+
+	manager, err := NewManager(&Config{CacheDir: t.TempDir(), MaxAge: 60, StaleMaxAge: 60, JanitorInterval: -1, JanitorBatchSize: 2})
+	require.NoError(t, err)
+	defer manager.Close()
+
+	age := func(key string, by time.Duration) {
+		modTime := time.Now().Add(-by)
+		require.NoError(t, os.Chtimes(manager.GetPath(key, "jpeg"), modTime, modTime))
+	}
+
+	for _, key := range []string{"fresh", "expired", "revalidate", "stale"} {
+		var validators *Validators
+		if key == "revalidate" || key == "stale" {
+			validators = &Validators{ETag: `"abc"`}
+		}
+
+		_, err = manager.Set(key, []byte("0123456789"), "jpeg", "", validators)
+		require.NoError(t, err)
+	}
+
+	age("expired", 2*time.Minute)
+	age("revalidate", 90*time.Second)
+	age("stale", 3*time.Minute)
+
+	result := manager.Sweep()
+	assert.Equal(t, SweepResult{Removed: 2, Bytes: 20}, result)
+
+	for key, exists := range map[string]bool{"fresh": true, "expired": false, "revalidate": true, "stale": false} {
+		_, err := os.Stat(manager.GetPath(key, "jpeg"))
+		assert.Equal(t, exists, err == nil, key)
+	}
+
+	_, err = os.Stat(metadataPath(manager.GetPath("stale", "jpeg")))
+	assert.True(t, os.IsNotExist(err), "sidecar files should be removed with their entry")
+
+	size, entries := manager.index.stats()
+	assert.Equal(t, int64(20), size)
+	assert.Equal(t, 2, entries)
+
+	assert.Equal(t, SweepResult{}, manager.Sweep(), "nothing left to reclaim")
+}
+
+func TestCacheManager_Janitor(t *testing.T) {
+	t.Parallel()
+
+	manager, err := NewManager(&Config{CacheDir: t.TempDir(), MaxAge: 60, JanitorInterval: 10 * time.Millisecond})
+	require.NoError(t, err)
+
+	_, err = manager.Set("expired", []byte("0123456789"), "jpeg", "", nil)
+	require.NoError(t, err)
+
+	modTime := time.Now().Add(-2 * time.Minute)
+	require.NoError(t, os.Chtimes(manager.GetPath("expired", "jpeg"), modTime, modTime))
+
+	assert.Eventually(t, func() bool {
+		_, err := os.Stat(manager.GetPath("expired", "jpeg"))
+		return os.IsNotExist(err)
+	}, time.Second, 10*time.Millisecond)
+
+	closed := make(chan struct{})
+	go func() {
+		manager.Close()
+		close(closed)
+	}()
+
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		t.Fatal("Close didn't stop the janitor in time")
+	}
+
+	assert.NoError(t, manager.Close(), "Close should be idempotent")
+}
@@ -1,26 +1,58 @@
 package managers
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
 	"fmt"
+	"io"
 	"log"
 	"os"
 	"path/filepath"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
 const (
-	DefaultCacheDir = "image_cache"
-	DefaultMaxAge   = int64(86400)
+	tempPrefix = ".tmp-"
+
+	DefaultCacheDir         = "image_cache"
+	DefaultMaxAge           = int64(86400)
+	DefaultEvictionInterval = time.Minute
 )
 
 type Config struct {
-	CacheDir string
-	MaxAge   int64
+	CacheDir         string
+	MaxAge           int64
+	MaxBytes         int64          // Upper bound of the total size of the cache, 0 for unlimited
+	MaxEntries       int            // Upper bound of the number of cached images, 0 for unlimited
+	Eviction         EvictionPolicy // Defaults to EvictLRU
+	EvictionInterval time.Duration  // How often the bounds are checked in addition to after writes
+	StaleMaxAge      int64          // How long expired entries that can be revalidated are kept, defaults to MaxAge
+	JanitorInterval  time.Duration  // How often expired entries are swept, negative to disable
+	JanitorBatchSize int            // Number of entries checked between interruption points of a sweep
 }
 
 type CacheManager struct {
-	cacheDir string
-	maxAge   int64
+	cacheDir         string
+	maxAge           int64
+	maxBytes         int64
+	maxEntries       int
+	eviction         EvictionPolicy
+	evictionInterval time.Duration
+	staleMaxAge      int64
+	janitorInterval  time.Duration
+	janitorBatchSize int
+	index            *index
+	evict            chan struct{}
+	done             chan struct{}
+	wg               sync.WaitGroup
+	closeOnce        sync.Once
+	hits             atomic.Int64
+	misses           atomic.Int64
+	expirations      atomic.Int64
+	evictions        atomic.Int64
 }
 
 func (m *CacheManager) ensureCacheDir() error {
@@ -40,9 +72,47 @@ func NewManager(cfg *Config) (*CacheManager, error) {
 		cfg.MaxAge = DefaultMaxAge
 	}
 
+	if cfg.MaxBytes < 0 || cfg.MaxEntries < 0 {
+		return nil, fmt.Errorf("cfg.MaxBytes and cfg.MaxEntries must not be negative!")
+	}
+
+	switch cfg.Eviction {
+	case "":
+		cfg.Eviction = EvictLRU
+	case EvictLRU, EvictLFU:
+	default:
+		return nil, fmt.Errorf("unsupported eviction policy: %s", cfg.Eviction)
+	}
+
+	if cfg.EvictionInterval <= 0 {
+		cfg.EvictionInterval = DefaultEvictionInterval
+	}
+
+	if cfg.StaleMaxAge == 0 {
+		cfg.StaleMaxAge = cfg.MaxAge
+	}
+
+	if cfg.JanitorInterval == 0 {
+		cfg.JanitorInterval = DefaultJanitorInterval
+	}
+
+	if cfg.JanitorBatchSize <= 0 {
+		cfg.JanitorBatchSize = DefaultJanitorBatchSize
+	}
+
 	m := &CacheManager{
-		cacheDir: cfg.CacheDir,
-		maxAge:   cfg.MaxAge,
+		cacheDir:         cfg.CacheDir,
+		maxAge:           cfg.MaxAge,
+		maxBytes:         cfg.MaxBytes,
+		maxEntries:       cfg.MaxEntries,
+		eviction:         cfg.Eviction,
+		evictionInterval: cfg.EvictionInterval,
+		staleMaxAge:      cfg.StaleMaxAge,
+		janitorInterval:  cfg.JanitorInterval,
+		janitorBatchSize: cfg.JanitorBatchSize,
+		index:            newIndex(),
+		evict:            make(chan struct{}, 1),
+		done:             make(chan struct{}),
 	}
 
 	err := m.ensureCacheDir()
@@ -50,37 +120,337 @@ func NewManager(cfg *Config) (*CacheManager, error) {
 		return nil, err
 	}
 
+	err = m.index.reconcile(m.cacheDir)
+	if err != nil {
+		return nil, err
+	}
+
+	if m.isBounded() {
+		m.wg.Add(1)
+		go m.evictor()
+		m.signalEviction()
+	}
+
+	if m.janitorInterval > 0 {
+		m.wg.Add(1)
+		go m.janitor()
+	}
+
 	return m, nil
 }
 
-func (m *CacheManager) Get(key string, format string) string {
-	path := m.GetPath(key, format)
+func (m *CacheManager) isBounded() bool {
+	return m.maxBytes > 0 || m.maxEntries > 0
+}
 
-	info, err := os.Stat(path)
+// Wakes up the evictor without blocking, a pending signal already covers this write.
+func (m *CacheManager) signalEviction() {
+	select {
+	case m.evict <- struct{}{}:
+	default:
+	}
+}
+
+// Removes entries in eviction order whenever the cache exceeds its bounds.
+func (m *CacheManager) evictor() {
+	defer m.wg.Done()
+
+	ticker := time.NewTicker(m.evictionInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-m.evict:
+		case <-ticker.C:
+		case <-m.done:
+			return
+		}
+
+		for _, path := range m.index.evict(m.maxBytes, m.maxEntries, m.eviction) {
+			m.removeFiles(path)
+			m.evictions.Add(1)
+		}
+	}
+}
+
+// Close stops the background eviction and janitor goroutines of the manager, waiting for them to finish.
+func (m *CacheManager) Close() error {
+	m.closeOnce.Do(func() {
+		close(m.done)
+	})
+	m.wg.Wait()
+	return nil
+}
+
+func (v *Validators) isZero() bool {
+	return v == nil || (v.ETag == "" && v.LastModified.IsZero())
+}
+
+// Validators and the content hash are stored in a sidecar file next to the cached image.
+func metadataPath(path string) string {
+	return path + ".meta"
+}
+
+type metadata struct {
+	Validators
+	Hash   string `json:"hash,omitempty"` // Hash of the image the validators belong to
+	Source string `json:"source,omitempty"`
+}
+
+func readMetadata(path string) *metadata {
+	data, err := os.ReadFile(metadataPath(path))
+	if err != nil {
+		return nil
+	}
+
+	meta := &metadata{}
+	err = json.Unmarshal(data, meta)
+	if err != nil {
+		return nil
+	}
+
+	return meta
+}
+
+func readValidators(path string) *Validators {
+	meta := readMetadata(path)
+	if meta == nil || meta.Validators.isZero() {
+		return nil
+	}
+
+	return &meta.Validators
+}
+
+func (m *CacheManager) remove(path string) {
+	m.index.remove(path)
+	m.removeFiles(path)
+}
+
+func (m *CacheManager) removeFiles(path string) {
+	err := os.Remove(path)
+	if err != nil && !os.IsNotExist(err) {
+		log.Println(err)
+	}
+
+	err = os.Remove(metadataPath(path))
+	if err != nil && !os.IsNotExist(err) {
+		log.Println(err)
+	}
+}
+
+// Opens the entry stored at path, if any. The caller must close the entry.
+func openEntry(path string, format string) (*Entry, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	entry := &Entry{
+		Body:        file,
+		Size:        info.Size(),
+		ContentType: ContentType(format),
+		CreatedAt:   info.ModTime(),
+	}
+
+	// The image is always hashed, the sidecar is replaced before the image and so may describe the next version, or a
+	// version whose image failed to be written, even when both are the same size
+	hash := sha256.New()
+	_, err = io.Copy(hash, file)
 	if err == nil {
-		if time.Now().Unix()-info.ModTime().Unix() <= m.maxAge {
-			return path
+		_, err = file.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	entry.Hash = hex.EncodeToString(hash.Sum(nil))
+
+	meta := readMetadata(path)
+	if meta != nil {
+		entry.Source = meta.Source
+
+		// Validators of another version would revalidate this image against an original it wasn't produced from
+		if meta.Hash == entry.Hash && !meta.Validators.isZero() {
+			entry.Validators = &meta.Validators
 		}
+	}
+
+	return entry, nil
+}
+
+// Get returns a fresh cache entry, or nil. Expired entries are removed unless they can be revalidated against their
+// upstream original.
+func (m *CacheManager) Get(key string, format string) *Entry {
+	path := m.GetPath(key, format)
 
-		err = os.Remove(path)
-		if err != nil {
-			log.Println(err)
-			return ""
+	info, err := os.Stat(path)
+	if err != nil {
+		// The file may have been evicted while being written
+		m.index.remove(path)
+		m.misses.Add(1)
+		return nil
+	}
+
+	if time.Now().Unix()-info.ModTime().Unix() > m.maxAge {
+		if readValidators(path) == nil {
+			m.remove(path)
+			m.expirations.Add(1)
 		}
+		m.misses.Add(1)
+		return nil
+	}
+
+	entry, err := openEntry(path, format)
+	if err != nil {
+		log.Println(err)
+		m.misses.Add(1)
+		return nil
+	}
+
+	m.index.touch(path)
+	m.hits.Add(1)
+	return entry
+}
+
+// Writes the file through a temporary file in the same directory which is renamed into place, so readers never
+// see partially written files and crashes only leave temporary files behind.
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), tempPrefix+"*")
+	if err != nil {
+		return err
+	}
+	// Fails harmlessly once the file was renamed
+	defer os.Remove(tmp.Name())
+
+	_, err = tmp.Write(data)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	err = os.Chmod(tmp.Name(), 0644)
+	if err != nil {
+		return err
 	}
 
-	return ""
+	return os.Rename(tmp.Name(), path)
 }
 
-func (m *CacheManager) Set(key string, img []byte, format string) (string, error) {
+func (m *CacheManager) Set(key string, img []byte, format string, source string, validators *Validators) (*Entry, error) {
 	path := m.GetPath(key, format)
-	return path, os.WriteFile(path, img, 0644)
+
+	err := os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		return nil, err
+	}
+
+	if validators.isZero() {
+		validators = nil
+	}
+
+	meta := &metadata{Hash: hashData(img), Source: source}
+	if validators != nil {
+		meta.Validators = *validators
+	}
+
+	data, err := json.Marshal(meta)
+	if err != nil {
+		return nil, err
+	}
+
+	// The sidecar is written first so a visible image is never paired with the validators of a previous version, while
+	// the image of the previous version is still visible openEntry finds the sidecar's hash doesn't match it
+	err = writeFileAtomic(metadataPath(path), data)
+	if err != nil {
+		return nil, err
+	}
+
+	err = writeFileAtomic(path, img)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	m.index.add(path, int64(len(img)), now, source)
+	if m.isBounded() {
+		m.signalEviction()
+	}
+
+	return NewEntry(img, format, now, source, validators), nil
 }
 
+// Stale returns an entry that can be revalidated against its upstream original regardless of its age, or nil.
+func (m *CacheManager) Stale(key string, format string) *Entry {
+	path := m.GetPath(key, format)
+
+	if readValidators(path) == nil {
+		return nil
+	}
+
+	entry, err := openEntry(path, format)
+	if err != nil {
+		return nil
+	}
+
+	return entry
+}
+
+// Refresh marks an entry as fresh again after its upstream original was revalidated as unchanged.
+func (m *CacheManager) Refresh(key string, format string) error {
+	path := m.GetPath(key, format)
+	now := time.Now()
+
+	err := os.Chtimes(path, now, now)
+	if err != nil {
+		return err
+	}
+
+	m.index.refresh(path, now)
+	return nil
+}
+
+// Purge removes every entry derived from the source, returning how many were removed.
+func (m *CacheManager) Purge(source string) (int, error) {
+	paths := m.index.variants(source)
+	for _, path := range paths {
+		m.remove(path)
+	}
+	return len(paths), nil
+}
+
+// Stats returns the counters of the cache along with the size, per format breakdown and age distribution of its
+// entries.
+func (m *CacheManager) Stats() Stats {
+	stats := m.index.snapshot(time.Now())
+	stats.Hits = m.hits.Load()
+	stats.Misses = m.misses.Load()
+	stats.Expirations = m.expirations.Load()
+	stats.Evictions = m.evictions.Load()
+	return stats
+}
+
+// GetPath returns where the entry is stored. Entries are fanned out into two levels of subdirectories named after
+// the start of the key (ab/cd/abcd....jpg) to avoid huge flat directories.
 func (m *CacheManager) GetPath(key string, format string) string {
 	ext := "jpg"
 	if format != "jpeg" {
 		ext = format
 	}
-	return filepath.Join(m.cacheDir, fmt.Sprintf("%s.%s", key, ext))
+
+	name := fmt.Sprintf("%s.%s", key, ext)
+	if len(key) < 4 {
+		return filepath.Join(m.cacheDir, name)
+	}
+	return filepath.Join(m.cacheDir, key[0:2], key[2:4], name)
 }
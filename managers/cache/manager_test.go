@@ -1,13 +1,17 @@
 package managers
 
 import (
+	"bytes"
+	"encoding/json"
 	"os"
 	"path/filepath"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/golang/mock/gomock"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestNewManager(t *testing.T) {
@@ -51,8 +55,10 @@ func TestCacheManager_Get(t *testing.T) {
 	tempDir := t.TempDir()
 
 	// Create test file
-	testPath := filepath.Join(tempDir, "my_key.jpg")
-	err := os.WriteFile(testPath, []byte("test"), 0644)
+	testPath := filepath.Join(tempDir, "my", "_k", "my_key.jpg")
+	err := os.MkdirAll(filepath.Dir(testPath), 0755)
+	assert.NoError(t, err)
+	err = os.WriteFile(testPath, []byte("test"), 0644)
 	assert.NoError(t, err)
 
 	tests := []struct {
@@ -60,21 +66,21 @@ func TestCacheManager_Get(t *testing.T) {
 		key          string
 		format       string
 		wait         time.Duration
-		expectedPath string
+		expectedData []byte
 	}{
 		{
 			name:         "successful processing with cache hit",
 			key:          "my_key",
 			format:       "jpeg",
 			wait:         0,
-			expectedPath: testPath,
+			expectedData: []byte("test"),
 		},
 		{
 			name:         "expired cache",
 			key:          "my_key",
 			format:       "jpeg",
 			wait:         time.Second * 2,
-			expectedPath: "",
+			expectedData: nil,
 		},
 	}
 
@@ -93,7 +99,11 @@ func TestCacheManager_Get(t *testing.T) {
 			}
 
 			result := manager.Get(tt.key, tt.format)
-			assert.Equal(t, tt.expectedPath, result)
+			if tt.expectedData == nil {
+				assert.Nil(t, result)
+			} else {
+				assert.Equal(t, tt.expectedData, readData(t, result))
+			}
 		})
 	}
 }
@@ -105,7 +115,7 @@ func TestCacheManager_Set(t *testing.T) {
 	testData := []byte("test image data")
 
 	// Create test file
-	testPath := filepath.Join(tempDir, "my_key.jpg")
+	testPath := filepath.Join(tempDir, "my", "_k", "my_key.jpg")
 
 	tests := []struct {
 		name          string
@@ -135,15 +145,15 @@ func TestCacheManager_Set(t *testing.T) {
 				t.FailNow()
 			}
 
-			path, err := manager.Set(tt.key, tt.img, tt.format)
+			entry, err := manager.Set(tt.key, tt.img, tt.format, "", nil)
 
 			if tt.expectedError {
 				assert.Error(t, err)
 			} else {
 				assert.NoError(t, err)
-				assert.Equal(t, tt.expectedPath, path)
+				assert.Equal(t, testData, readData(t, entry))
 
-				savedData, err := os.ReadFile(path)
+				savedData, err := os.ReadFile(tt.expectedPath)
 				assert.NoError(t, err)
 				assert.Equal(t, testData, savedData)
 			}
@@ -157,19 +167,241 @@ func TestCacheManager_Set(t *testing.T) {
 			t.FailNow()
 		}
 
-		path, err := manager.Set("expiretest", testData, "jpeg")
+		_, err = manager.Set("expiretest", testData, "jpeg", "", nil)
 		assert.NoError(t, err)
 
 		time.Sleep(2 * time.Second)
 
-		cachedPath := manager.Get("expiretest", "jpeg")
-		assert.Empty(t, cachedPath)
+		cached := manager.Get("expiretest", "jpeg")
+		assert.Nil(t, cached)
 
-		_, err = os.Stat(path)
+		_, err = os.Stat(manager.GetPath("expiretest", "jpeg"))
 		assert.True(t, os.IsNotExist(err))
 	})
 }
 
+func TestCacheManager_Revalidation(t *testing.T) {
+	t.Parallel()
+
+	tempDir := t.TempDir()
+	validators := &Validators{ETag: `"abc"`, LastModified: time.Unix(1445412480, 0).UTC()}
+
+	manager, err := NewManager(&Config{CacheDir: tempDir, MaxAge: 60})
+	if err != nil {
+		t.FailNow()
+	}
+
+	_, err = manager.Set("revalidate", []byte("test image data"), "jpeg", "", validators)
+	assert.NoError(t, err)
+	assert.NotNil(t, manager.Get("revalidate", "jpeg"))
+	path := manager.GetPath("revalidate", "jpeg")
+
+	// Expire the entry
+	expired := time.Now().Add(-2 * time.Minute)
+	err = os.Chtimes(path, expired, expired)
+	assert.NoError(t, err)
+
+	assert.Nil(t, manager.Get("revalidate", "jpeg"))
+	_, err = os.Stat(path)
+	assert.NoError(t, err, "expired entries with validators should be kept for revalidation")
+
+	stale := manager.Stale("revalidate", "jpeg")
+	if assert.NotNil(t, stale) {
+		assert.Equal(t, []byte("test image data"), readData(t, stale))
+		assert.Equal(t, validators, stale.Validators)
+	}
+
+	err = manager.Refresh("revalidate", "jpeg")
+	assert.NoError(t, err)
+	assert.NotNil(t, manager.Get("revalidate", "jpeg"))
+
+	// Entries without validators can't be revalidated
+	_, err = manager.Set("revalidate", []byte("test image data"), "jpeg", "", nil)
+	assert.NoError(t, err)
+	assert.Nil(t, manager.Stale("revalidate", "jpeg"))
+}
+
+func TestCacheManager_Entry(t *testing.T) {
+	t.Parallel()
+
+	tempDir := t.TempDir()
+	data := []byte("test image data")
+	hash := hashData(data)
+
+	manager, err := NewManager(&Config{CacheDir: tempDir, MaxAge: 60})
+	require.NoError(t, err)
+	defer manager.Close()
+
+	_, err = manager.Set("streamed", data, "webp", "", nil)
+	require.NoError(t, err)
+
+	// Entries are streamed from the file instead of being read into memory
+	entry := manager.Get("streamed", "webp")
+	require.NotNil(t, entry)
+	_, ok := entry.Body.(*os.File)
+	assert.True(t, ok)
+	assert.Equal(t, int64(len(data)), entry.Size)
+	assert.Equal(t, "image/webp", entry.ContentType)
+	assert.Equal(t, hash, entry.Hash)
+	assert.Equal(t, data, readData(t, entry))
+
+	// Files without a stored hash are hashed when read
+	require.NoError(t, os.Remove(metadataPath(manager.GetPath("streamed", "webp"))))
+	entry = manager.Get("streamed", "webp")
+	require.NotNil(t, entry)
+	assert.Equal(t, hash, entry.Hash)
+	assert.Equal(t, data, readData(t, entry))
+
+	// The sidecar of a same size version whose image isn't written yet doesn't describe the visible image
+	next := &metadata{Hash: hashData([]byte("next image data")), Validators: Validators{ETag: `"next"`}}
+	sidecar, err := json.Marshal(next)
+	require.NoError(t, err)
+	require.NoError(t, writeFileAtomic(metadataPath(manager.GetPath("streamed", "webp")), sidecar))
+	entry = manager.Get("streamed", "webp")
+	require.NotNil(t, entry)
+	assert.Equal(t, hash, entry.Hash)
+	assert.Nil(t, entry.Validators)
+	assert.Equal(t, data, readData(t, entry))
+}
+
+func TestCacheManager_Purge(t *testing.T) {
+	t.Parallel()
+
+	tempDir := t.TempDir()
+	source := "http://imgur.com/image.jpg"
+
+	manager, err := NewManager(&Config{CacheDir: tempDir, MaxAge: 60})
+	require.NoError(t, err)
+
+	for _, key := range []string{"small", "large"} {
+		_, err = manager.Set(key, []byte("test image data"), "jpeg", source, nil)
+		require.NoError(t, err)
+	}
+	_, err = manager.Set("other", []byte("test image data"), "jpeg", "http://imgur.com/other.jpg", nil)
+	require.NoError(t, err)
+
+	purged, err := manager.Purge(source)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, purged)
+
+	for _, key := range []string{"small", "large"} {
+		assert.Nil(t, manager.Get(key, "jpeg"))
+		_, err = os.Stat(metadataPath(manager.GetPath(key, "jpeg")))
+		assert.True(t, os.IsNotExist(err), "sidecar files should be removed with their entry")
+	}
+	assert.NotNil(t, readData(t, manager.Get("other", "jpeg")))
+	manager.Close()
+
+	// Sources are rebuilt from the sidecar files at startup
+	restarted, err := NewManager(&Config{CacheDir: tempDir, MaxAge: 60})
+	require.NoError(t, err)
+	defer restarted.Close()
+
+	purged, err = restarted.Purge("http://imgur.com/other.jpg")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, purged)
+	assert.Nil(t, restarted.Get("other", "jpeg"))
+
+	purged, err = restarted.Purge(source)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, purged)
+}
+
+func TestCacheManager_Eviction(t *testing.T) {
+	t.Parallel()
+
+	tempDir := t.TempDir()
+
+	// Entries left over from a previous run are picked up at startup
+	err := os.WriteFile(filepath.Join(tempDir, "old.jpg"), []byte("0123456789"), 0644)
+	assert.NoError(t, err)
+	old := time.Now().Add(-time.Hour)
+	assert.NoError(t, os.Chtimes(filepath.Join(tempDir, "old.jpg"), old, old))
+
+	manager, err := NewManager(&Config{CacheDir: tempDir, MaxAge: 3600, MaxBytes: 25, EvictionInterval: time.Hour})
+	if err != nil {
+		t.FailNow()
+	}
+	defer manager.Close()
+
+	_, err = manager.Set("first", []byte("0123456789"), "jpeg", "", nil)
+	assert.NoError(t, err)
+
+	_, err = manager.Set("second", []byte("0123456789"), "jpeg", "", nil)
+	assert.NoError(t, err)
+
+	// The oldest entry is evicted in the background
+	assert.Eventually(t, func() bool {
+		_, err := os.Stat(filepath.Join(tempDir, "old.jpg"))
+		return os.IsNotExist(err)
+	}, time.Second, 10*time.Millisecond)
+
+	assert.NotNil(t, manager.Get("first", "jpeg"))
+	assert.NotNil(t, manager.Get("second", "jpeg"))
+
+	size, entries := manager.index.stats()
+	assert.Equal(t, int64(20), size)
+	assert.Equal(t, 2, entries)
+	assert.Equal(t, int64(1), manager.Stats().Evictions)
+
+	t.Run("invalid eviction policy", func(t *testing.T) {
+		_, err := NewManager(&Config{CacheDir: tempDir, Eviction: "random"})
+		assert.Equal(t, "unsupported eviction policy: random", err.Error())
+	})
+}
+
+func TestCacheManager_Stats(t *testing.T) {
+	t.Parallel()
+
+	manager, err := NewManager(&Config{CacheDir: t.TempDir(), MaxAge: 3600, JanitorInterval: -1})
+	require.NoError(t, err)
+	defer manager.Close()
+
+	_, err = manager.Set("jpeg", []byte("0123456789"), "jpeg", "", nil)
+	require.NoError(t, err)
+	_, err = manager.Set("webp", []byte("01234"), "webp", "", nil)
+	require.NoError(t, err)
+	_, err = manager.Set("old", []byte("01234"), "webp", "", nil)
+	require.NoError(t, err)
+	_, err = manager.Set("expired", []byte("01234"), "png", "", nil)
+	require.NoError(t, err)
+
+	age := func(key, format string, by time.Duration) {
+		modTime := time.Now().Add(-by)
+		path := manager.GetPath(key, format)
+		require.NoError(t, os.Chtimes(path, modTime, modTime))
+		manager.index.refresh(path, modTime)
+	}
+	age("old", "webp", 3*time.Hour)
+	age("expired", "png", 2*time.Hour)
+
+	assert.NotNil(t, manager.Get("jpeg", "jpeg"))
+	assert.Nil(t, manager.Get("missing", "jpeg"))
+	assert.Nil(t, manager.Get("expired", "png"))
+
+	stats := manager.Stats()
+	assert.Equal(t, int64(1), stats.Hits)
+	assert.Equal(t, int64(2), stats.Misses)
+	assert.Equal(t, int64(1), stats.Expirations)
+	assert.Equal(t, int64(0), stats.Evictions)
+	assert.Equal(t, int64(20), stats.Bytes)
+	assert.Equal(t, 3, stats.Entries)
+	assert.Equal(t, map[string]FormatStats{
+		"jpeg": {Bytes: 10, Entries: 1},
+		"webp": {Bytes: 10, Entries: 2},
+	}, stats.Formats)
+
+	require.Len(t, stats.Ages, len(AgeBuckets))
+	assert.Equal(t, AgeBucket{MaxAge: 60, Entries: 2}, stats.Ages[0])
+	assert.Equal(t, AgeBucket{MaxAge: 3600, Entries: 2}, stats.Ages[1])
+	assert.Equal(t, AgeBucket{MaxAge: 6 * 3600, Entries: 3}, stats.Ages[2])
+	assert.InDelta(t, 3*3600, stats.AgeSum, 5)
+
+	// Refreshing an entry makes it young again
+	require.NoError(t, manager.Refresh("old", "webp"))
+	assert.Equal(t, 3, manager.Stats().Ages[0].Entries)
+}
+
 func TestCacheManager_GetPath(t *testing.T) {
 	t.Parallel()
 
@@ -185,19 +417,19 @@ func TestCacheManager_GetPath(t *testing.T) {
 			name:     "jpeg format",
 			key:      "test123",
 			format:   "jpeg",
-			expected: filepath.Join(testDir, "test123.jpg"),
+			expected: filepath.Join(testDir, "te", "st", "test123.jpg"),
 		},
 		{
 			name:     "png format",
 			key:      "test123",
 			format:   "png",
-			expected: filepath.Join(testDir, "test123.png"),
+			expected: filepath.Join(testDir, "te", "st", "test123.png"),
 		},
 		{
 			name:     "webp format",
 			key:      "test123",
 			format:   "webp",
-			expected: filepath.Join(testDir, "test123.webp"),
+			expected: filepath.Join(testDir, "te", "st", "test123.webp"),
 		},
 	}
 
@@ -215,4 +447,79 @@ func TestCacheManager_GetPath(t *testing.T) {
 			assert.Equal(t, tt.expected, result)
 		})
 	}
+
+	t.Run("short key", func(t *testing.T) {
+		assert.Equal(t, filepath.Join(testDir, "abc.jpg"), manager.GetPath("abc", "jpeg"))
+	})
+}
+
+func TestCacheManager_AtomicWrites(t *testing.T) {
+	t.Parallel()
+
+	tempDir := t.TempDir()
+
+	// Leftovers of a write interrupted by a crash
+	orphan := filepath.Join(tempDir, "ab", "cd", tempPrefix+"123456")
+	assert.NoError(t, os.MkdirAll(filepath.Dir(orphan), 0755))
+	assert.NoError(t, os.WriteFile(orphan, []byte("truncated"), 0644))
+
+	manager, err := NewManager(&Config{CacheDir: tempDir, MaxAge: 3600})
+	if err != nil {
+		t.FailNow()
+	}
+	defer manager.Close()
+
+	_, err = os.Stat(orphan)
+	assert.True(t, os.IsNotExist(err), "orphaned temporary files should be removed at startup")
+
+	_, entries := manager.index.stats()
+	assert.Equal(t, 0, entries)
+
+	// Concurrent readers only ever see complete files
+	small := []byte("small")
+	large := bytes.Repeat([]byte("large"), 64*1024)
+
+	var wg sync.WaitGroup
+	done := make(chan struct{})
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; ; i++ {
+			select {
+			case <-done:
+				return
+			default:
+			}
+
+			data := small
+			if i%2 == 0 {
+				data = large
+			}
+			_, err := manager.Set("abcdef", data, "jpeg", "", &Validators{ETag: `"abc"`})
+			assert.NoError(t, err)
+		}
+	}()
+
+	for i := 0; i < 200; i++ {
+		entry := manager.Get("abcdef", "jpeg")
+		if entry != nil {
+			assert.True(t, bytes.Equal(small, readData(t, entry)) || bytes.Equal(large, readData(t, entry)), "read a partially written file")
+		}
+	}
+	close(done)
+	wg.Wait()
+
+	matches, err := filepath.Glob(filepath.Join(tempDir, "ab", "cd", tempPrefix+"*"))
+	assert.NoError(t, err)
+	assert.Empty(t, matches, "temporary files should not be left behind")
+}
+
+// Reads and closes the entry
+func readData(t *testing.T, entry *Entry) []byte {
+	t.Helper()
+	defer entry.Close()
+
+	data, err := entry.ReadAll()
+	assert.NoError(t, err)
+	return data
 }
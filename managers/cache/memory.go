@@ -0,0 +1,232 @@
+package managers
+
+import (
+	"bytes"
+	"container/list"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type MemoryConfig struct {
+	Next     Manager // Slower tier behind the memory cache, usually the disk CacheManager
+	MaxBytes int64   // Upper bound of the total size of the cached images
+	MaxAge   int64
+}
+
+type memoryItem struct {
+	key   string
+	data  []byte
+	entry Entry // Body is nil, every reader gets its own
+}
+
+func (i *memoryItem) newEntry() *Entry {
+	entry := i.entry
+	entry.Body = bytes.NewReader(i.data)
+	return &entry
+}
+
+// MemoryManager is an in-memory LRU cache in front of another Manager. Hot entries are served from memory while the
+// next tier holds the long tail, every write goes through to the next tier.
+type MemoryManager struct {
+	next        Manager
+	maxBytes    int64
+	maxAge      int64
+	mu          sync.Mutex
+	items       map[string]*list.Element
+	order       *list.List // Most recently used at the front
+	size        int64
+	hits        atomic.Int64
+	misses      atomic.Int64
+	expirations atomic.Int64
+	evictions   atomic.Int64
+}
+
+func NewMemoryManager(cfg *MemoryConfig) (*MemoryManager, error) {
+	if cfg == nil {
+		return nil, fmt.Errorf("MemoryManager config is nil!")
+	}
+
+	if cfg.Next == nil {
+		return nil, fmt.Errorf("cfg.Next is nil!")
+	}
+
+	if cfg.MaxBytes <= 0 {
+		return nil, fmt.Errorf("cfg.MaxBytes must be positive!")
+	}
+
+	if cfg.MaxAge == 0 {
+		cfg.MaxAge = DefaultMaxAge
+	}
+
+	return &MemoryManager{
+		next:     cfg.Next,
+		maxBytes: cfg.MaxBytes,
+		maxAge:   cfg.MaxAge,
+		items:    map[string]*list.Element{},
+		order:    list.New(),
+	}, nil
+}
+
+func memoryKey(key string, format string) string {
+	return key + "." + format
+}
+
+func (m *MemoryManager) isFresh(entry *Entry) bool {
+	return time.Now().Unix()-entry.CreatedAt.Unix() <= m.maxAge
+}
+
+func (m *MemoryManager) removeElement(element *list.Element) {
+	item := m.order.Remove(element).(*memoryItem)
+	delete(m.items, item.key)
+	m.size -= int64(len(item.data))
+}
+
+func (m *MemoryManager) add(key string, data []byte, entry *Entry) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if element, ok := m.items[key]; ok {
+		m.removeElement(element)
+	}
+
+	size := int64(len(data))
+	if size > m.maxBytes {
+		return
+	}
+
+	for m.size+size > m.maxBytes {
+		m.removeElement(m.order.Back())
+		m.evictions.Add(1)
+	}
+
+	item := &memoryItem{key: key, data: data, entry: *entry}
+	item.entry.Body = nil
+	m.items[key] = m.order.PushFront(item)
+	m.size += size
+}
+
+func (m *MemoryManager) remove(key string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if element, ok := m.items[key]; ok {
+		m.removeElement(element)
+	}
+}
+
+func (m *MemoryManager) lookup(key string) *Entry {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	element, ok := m.items[key]
+	if !ok {
+		return nil
+	}
+
+	item := element.Value.(*memoryItem)
+	if !m.isFresh(&item.entry) {
+		m.removeElement(element)
+		m.expirations.Add(1)
+		return nil
+	}
+
+	m.order.MoveToFront(element)
+	return item.newEntry()
+}
+
+func (m *MemoryManager) Get(key string, format string) *Entry {
+	mkey := memoryKey(key, format)
+
+	entry := m.lookup(mkey)
+	if entry != nil {
+		m.hits.Add(1)
+		return entry
+	}
+	m.misses.Add(1)
+
+	entry = m.next.Get(key, format)
+	if entry == nil || entry.Size > m.maxBytes {
+		return entry
+	}
+
+	// Promoted entries are buffered and served from memory from now on
+	data, err := entry.ReadAll()
+	entry.Close()
+	if err != nil {
+		log.Println(err)
+		return nil
+	}
+
+	m.add(mkey, data, entry)
+	return NewEntry(data, format, entry.CreatedAt, entry.Source, entry.Validators)
+}
+
+func (m *MemoryManager) Set(key string, img []byte, format string, source string, validators *Validators) (*Entry, error) {
+	entry, err := m.next.Set(key, img, format, source, validators)
+	if err != nil {
+		return nil, err
+	}
+
+	m.add(memoryKey(key, format), img, entry)
+	return entry, nil
+}
+
+// Stale entries are only kept by the next tier.
+func (m *MemoryManager) Stale(key string, format string) *Entry {
+	return m.next.Stale(key, format)
+}
+
+func (m *MemoryManager) Refresh(key string, format string) error {
+	// The refreshed entry is promoted again on its next Get
+	m.remove(memoryKey(key, format))
+	return m.next.Refresh(key, format)
+}
+
+// Purge removes every entry derived from the source from memory and the next tier.
+func (m *MemoryManager) Purge(source string) (int, error) {
+	m.mu.Lock()
+	for element := m.order.Front(); element != nil; {
+		next := element.Next()
+		if element.Value.(*memoryItem).entry.Source == source {
+			m.removeElement(element)
+		}
+		element = next
+	}
+	m.mu.Unlock()
+
+	return m.next.Purge(source)
+}
+
+// Stats returns the counters of the memory tier along with the size, per format breakdown and age distribution of
+// the entries it holds. Lookups answered by the next tier are counted as misses.
+func (m *MemoryManager) Stats() Stats {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	stats := newStats()
+	for _, element := range m.items {
+		item := element.Value.(*memoryItem)
+		format := strings.TrimPrefix(item.entry.ContentType, "image/")
+		stats.add(format, int64(len(item.data)), now.Sub(item.entry.CreatedAt))
+	}
+
+	stats.Hits = m.hits.Load()
+	stats.Misses = m.misses.Load()
+	stats.Expirations = m.expirations.Load()
+	stats.Evictions = m.evictions.Load()
+	return stats
+}
+
+// Close closes the next tier if it holds resources.
+func (m *MemoryManager) Close() error {
+	if closer, ok := m.next.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
@@ -0,0 +1,198 @@
+package managers
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewMemoryManager(t *testing.T) {
+	t.Parallel()
+
+	disk, err := NewManager(&Config{CacheDir: t.TempDir()})
+	require.NoError(t, err)
+
+	tests := []struct {
+		name     string
+		config   *MemoryConfig
+		expected string
+	}{
+		{
+			name:     "Config is nil",
+			config:   nil,
+			expected: "MemoryManager config is nil!",
+		},
+		{
+			name:     "cfg.Next is nil",
+			config:   &MemoryConfig{MaxBytes: 1024},
+			expected: "cfg.Next is nil!",
+		},
+		{
+			name:     "cfg.MaxBytes is 0",
+			config:   &MemoryConfig{Next: disk},
+			expected: "cfg.MaxBytes must be positive!",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewMemoryManager(tt.config)
+			assert.Equal(t, tt.expected, err.Error())
+		})
+	}
+}
+
+func TestMemoryManager_Get(t *testing.T) {
+	t.Parallel()
+
+	disk, err := NewManager(&Config{CacheDir: t.TempDir(), MaxAge: 60})
+	require.NoError(t, err)
+
+	_, err = disk.Set("cold", []byte("cold data"), "jpeg", "", nil)
+	require.NoError(t, err)
+
+	manager, err := NewMemoryManager(&MemoryConfig{Next: disk, MaxBytes: 1024, MaxAge: 60})
+	require.NoError(t, err)
+
+	// Miss in memory, promoted from the disk tier
+	entry := manager.Get("cold", "jpeg")
+	require.NotNil(t, entry)
+	assert.Equal(t, []byte("cold data"), readData(t, entry))
+	stats := manager.Stats()
+	assert.Equal(t, int64(0), stats.Hits)
+	assert.Equal(t, int64(1), stats.Misses)
+	assert.Equal(t, int64(9), stats.Bytes)
+	assert.Equal(t, 1, stats.Entries)
+
+	// Served from memory even when the disk tier loses it
+	disk.remove(disk.GetPath("cold", "jpeg"))
+	entry = manager.Get("cold", "jpeg")
+	require.NotNil(t, entry)
+	assert.Equal(t, []byte("cold data"), readData(t, entry))
+	assert.Equal(t, int64(1), manager.Stats().Hits)
+
+	// Missing from both tiers
+	assert.Nil(t, manager.Get("missing", "jpeg"))
+	assert.Equal(t, int64(2), manager.Stats().Misses)
+
+	// Formats are cached separately
+	assert.Nil(t, manager.Get("cold", "png"))
+}
+
+func TestMemoryManager_Set(t *testing.T) {
+	t.Parallel()
+
+	disk, err := NewManager(&Config{CacheDir: t.TempDir(), MaxAge: 60})
+	require.NoError(t, err)
+
+	manager, err := NewMemoryManager(&MemoryConfig{Next: disk, MaxBytes: 10, MaxAge: 60})
+	require.NoError(t, err)
+
+	_, err = manager.Set("a", []byte("aaaa"), "jpeg", "", nil)
+	require.NoError(t, err)
+	assert.NotNil(t, disk.Get("a", "jpeg"), "writes should go through to the next tier")
+
+	_, err = manager.Set("b", []byte("bbbb"), "jpeg", "", nil)
+	require.NoError(t, err)
+
+	// Touch a so that b is the least recently used entry
+	assert.NotNil(t, manager.Get("a", "jpeg"))
+
+	_, err = manager.Set("c", []byte("cccc"), "jpeg", "", nil)
+	require.NoError(t, err)
+
+	stats := manager.Stats()
+	assert.Equal(t, int64(8), stats.Bytes)
+	assert.Equal(t, 2, stats.Entries)
+
+	manager.mu.Lock()
+	assert.Contains(t, manager.items, memoryKey("a", "jpeg"))
+	assert.NotContains(t, manager.items, memoryKey("b", "jpeg"), "least recently used entry should be evicted")
+	assert.Contains(t, manager.items, memoryKey("c", "jpeg"))
+	manager.mu.Unlock()
+	assert.Equal(t, int64(1), stats.Evictions)
+	assert.Equal(t, map[string]FormatStats{"jpeg": {Bytes: 8, Entries: 2}}, stats.Formats)
+
+	// Entries larger than the whole budget are only stored in the next tier
+	_, err = manager.Set("large", []byte("larger than ten bytes"), "jpeg", "", nil)
+	require.NoError(t, err)
+	assert.Equal(t, 2, manager.Stats().Entries)
+	assert.NotNil(t, disk.Get("large", "jpeg"))
+}
+
+func TestMemoryManager_Expiration(t *testing.T) {
+	t.Parallel()
+
+	disk, err := NewManager(&Config{CacheDir: t.TempDir(), MaxAge: 60})
+	require.NoError(t, err)
+
+	manager, err := NewMemoryManager(&MemoryConfig{Next: disk, MaxBytes: 1024, MaxAge: 60})
+	require.NoError(t, err)
+
+	validators := &Validators{ETag: `"abc"`}
+	_, err = manager.Set("key", []byte("data"), "jpeg", "", validators)
+	require.NoError(t, err)
+
+	// Expire the entry held in memory
+	item := manager.items[memoryKey("key", "jpeg")].Value.(*memoryItem)
+	item.entry.CreatedAt = time.Now().Add(-2 * time.Minute)
+	assert.Nil(t, manager.lookup(memoryKey("key", "jpeg")))
+	assert.Equal(t, 0, manager.Stats().Entries)
+	assert.Equal(t, int64(1), manager.Stats().Expirations)
+
+	stale := manager.Stale("key", "jpeg")
+	require.NotNil(t, stale)
+	assert.Equal(t, validators, stale.Validators)
+
+	assert.NoError(t, manager.Refresh("key", "jpeg"))
+	assert.NotNil(t, manager.Get("key", "jpeg"))
+}
+
+func TestMemoryManager_Purge(t *testing.T) {
+	t.Parallel()
+
+	disk, err := NewManager(&Config{CacheDir: t.TempDir(), MaxAge: 60})
+	require.NoError(t, err)
+
+	manager, err := NewMemoryManager(&MemoryConfig{Next: disk, MaxBytes: 1024, MaxAge: 60})
+	require.NoError(t, err)
+
+	_, err = manager.Set("written", []byte("data"), "jpeg", "http://imgur.com/image.jpg", nil)
+	require.NoError(t, err)
+	_, err = disk.Set("promoted", []byte("data"), "jpeg", "http://imgur.com/image.jpg", nil)
+	require.NoError(t, err)
+	_, err = manager.Set("other", []byte("data"), "jpeg", "http://imgur.com/other.jpg", nil)
+	require.NoError(t, err)
+
+	require.NotNil(t, manager.Get("promoted", "jpeg"))
+	assert.Equal(t, 3, manager.Stats().Entries)
+
+	purged, err := manager.Purge("http://imgur.com/image.jpg")
+	assert.NoError(t, err)
+	assert.Equal(t, 2, purged)
+	assert.Equal(t, 1, manager.Stats().Entries)
+
+	assert.Nil(t, manager.Get("written", "jpeg"))
+	assert.Nil(t, manager.Get("promoted", "jpeg"))
+	assert.NotNil(t, manager.Get("other", "jpeg"))
+}
+
+func TestMemoryManager_Close(t *testing.T) {
+	t.Parallel()
+
+	disk, err := NewManager(&Config{CacheDir: t.TempDir(), MaxAge: 60})
+	require.NoError(t, err)
+
+	manager, err := NewMemoryManager(&MemoryConfig{Next: disk, MaxBytes: 1024, MaxAge: 60})
+	require.NoError(t, err)
+
+	// The next tier's background goroutines are stopped
+	assert.NoError(t, manager.Close())
+	select {
+	case <-disk.done:
+	default:
+		t.Error("the next tier should be closed")
+	}
+}
@@ -5,6 +5,7 @@
 package mock_managers
 
 import (
+	managers "antman-proxy/managers/cache"
 	reflect "reflect"
 
 	gomock "github.com/golang/mock/gomock"
@@ -34,10 +35,10 @@ func (m *MockManager) EXPECT() *MockManagerMockRecorder {
 }
 
 // Get mocks base method.
-func (m *MockManager) Get(key, format string) string {
+func (m *MockManager) Get(key, format string) *managers.Entry {
 	m.ctrl.T.Helper()
 	ret := m.ctrl.Call(m, "Get", key, format)
-	ret0, _ := ret[0].(string)
+	ret0, _ := ret[0].(*managers.Entry)
 	return ret0
 }
 
@@ -47,31 +48,60 @@ func (mr *MockManagerMockRecorder) Get(key, format interface{}) *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Get", reflect.TypeOf((*MockManager)(nil).Get), key, format)
 }
 
-// GetPath mocks base method.
-func (m *MockManager) GetPath(key, format string) string {
+// Purge mocks base method.
+func (m *MockManager) Purge(source string) (int, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "GetPath", key, format)
-	ret0, _ := ret[0].(string)
+	ret := m.ctrl.Call(m, "Purge", source)
+	ret0, _ := ret[0].(int)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Purge indicates an expected call of Purge.
+func (mr *MockManagerMockRecorder) Purge(source interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Purge", reflect.TypeOf((*MockManager)(nil).Purge), source)
+}
+
+// Refresh mocks base method.
+func (m *MockManager) Refresh(key, format string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Refresh", key, format)
+	ret0, _ := ret[0].(error)
 	return ret0
 }
 
-// GetPath indicates an expected call of GetPath.
-func (mr *MockManagerMockRecorder) GetPath(key, format interface{}) *gomock.Call {
+// Refresh indicates an expected call of Refresh.
+func (mr *MockManagerMockRecorder) Refresh(key, format interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetPath", reflect.TypeOf((*MockManager)(nil).GetPath), key, format)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Refresh", reflect.TypeOf((*MockManager)(nil).Refresh), key, format)
 }
 
 // Set mocks base method.
-func (m *MockManager) Set(key string, img []byte, format string) (string, error) {
+func (m *MockManager) Set(key string, img []byte, format, source string, validators *managers.Validators) (*managers.Entry, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "Set", key, img, format)
-	ret0, _ := ret[0].(string)
+	ret := m.ctrl.Call(m, "Set", key, img, format, source, validators)
+	ret0, _ := ret[0].(*managers.Entry)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
 // Set indicates an expected call of Set.
-func (mr *MockManagerMockRecorder) Set(key, img, format interface{}) *gomock.Call {
+func (mr *MockManagerMockRecorder) Set(key, img, format, source, validators interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Set", reflect.TypeOf((*MockManager)(nil).Set), key, img, format, source, validators)
+}
+
+// Stale mocks base method.
+func (m *MockManager) Stale(key, format string) *managers.Entry {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Stale", key, format)
+	ret0, _ := ret[0].(*managers.Entry)
+	return ret0
+}
+
+// Stale indicates an expected call of Stale.
+func (mr *MockManagerMockRecorder) Stale(key, format interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Set", reflect.TypeOf((*MockManager)(nil).Set), key, img, format)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Stale", reflect.TypeOf((*MockManager)(nil).Stale), key, format)
 }
@@ -0,0 +1,218 @@
+package managers
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const DefaultRedisPrefix = "antman:"
+
+type RedisConfig struct {
+	Client      redis.UniversalClient
+	Prefix      string // Prepended to every key, defaults to DefaultRedisPrefix
+	MaxAge      int64
+	StaleMaxAge int64 // How long expired entries that can be revalidated are kept, defaults to MaxAge
+}
+
+// RedisManager stores entries in Redis so they're shared by every replica. Each entry is a hash holding the image
+// and its validators, expired entries are removed by Redis once they can no longer be revalidated.
+type RedisManager struct {
+	client      redis.UniversalClient
+	prefix      string
+	maxAge      int64
+	staleMaxAge int64
+}
+
+func NewRedisManager(cfg *RedisConfig) (*RedisManager, error) {
+	if cfg == nil {
+		return nil, fmt.Errorf("RedisManager config is nil!")
+	}
+
+	if cfg.Client == nil {
+		return nil, fmt.Errorf("cfg.Client is nil!")
+	}
+
+	if cfg.Prefix == "" {
+		cfg.Prefix = DefaultRedisPrefix
+	}
+
+	if cfg.MaxAge == 0 {
+		cfg.MaxAge = DefaultMaxAge
+	}
+
+	if cfg.StaleMaxAge == 0 {
+		cfg.StaleMaxAge = cfg.MaxAge
+	}
+
+	return &RedisManager{
+		client:      cfg.Client,
+		prefix:      cfg.Prefix,
+		maxAge:      cfg.MaxAge,
+		staleMaxAge: cfg.StaleMaxAge,
+	}, nil
+}
+
+func (m *RedisManager) key(key string, format string) string {
+	return m.prefix + key + "." + format
+}
+
+// Key of the set holding the keys of the variants derived from the source.
+func (m *RedisManager) sourceKey(source string) string {
+	return m.prefix + "source:" + hashData([]byte(source))
+}
+
+// Entries that can be revalidated are kept past their max age.
+func (m *RedisManager) ttl(revalidatable bool) time.Duration {
+	if !revalidatable {
+		return time.Duration(m.maxAge) * time.Second
+	}
+	return time.Duration(m.maxAge+m.staleMaxAge) * time.Second
+}
+
+func (m *RedisManager) read(key string, format string) *Entry {
+	fields, err := m.client.HGetAll(context.Background(), m.key(key, format)).Result()
+	if err != nil {
+		log.Println(err)
+		return nil
+	}
+
+	data, ok := fields["data"]
+	if !ok {
+		return nil
+	}
+
+	modTime, err := strconv.ParseInt(fields["mod_time"], 10, 64)
+	if err != nil {
+		return nil
+	}
+
+	validators := &Validators{ETag: fields["etag"]}
+	if lastModified, err := strconv.ParseInt(fields["last_modified"], 10, 64); err == nil {
+		validators.LastModified = time.Unix(lastModified, 0).UTC()
+	}
+	if validators.isZero() {
+		validators = nil
+	}
+
+	return NewEntry([]byte(data), format, time.Unix(0, modTime), fields["source"], validators)
+}
+
+func (m *RedisManager) Get(key string, format string) *Entry {
+	entry := m.read(key, format)
+	if entry == nil || time.Now().Unix()-entry.CreatedAt.Unix() > m.maxAge {
+		return nil
+	}
+	return entry
+}
+
+func (m *RedisManager) Set(key string, img []byte, format string, source string, validators *Validators) (*Entry, error) {
+	if validators.isZero() {
+		validators = nil
+	}
+
+	now := time.Now()
+	fields := map[string]interface{}{
+		"data":     img,
+		"mod_time": now.UnixNano(),
+	}
+	if source != "" {
+		fields["source"] = source
+	}
+	if validators != nil {
+		fields["etag"] = validators.ETag
+		if !validators.LastModified.IsZero() {
+			fields["last_modified"] = validators.LastModified.Unix()
+		}
+	}
+
+	// The hash is replaced as a whole so validators of a previous version don't linger
+	rkey := m.key(key, format)
+	_, err := m.client.TxPipelined(context.Background(), func(pipe redis.Pipeliner) error {
+		pipe.Del(context.Background(), rkey)
+		pipe.HSet(context.Background(), rkey, fields)
+		pipe.Expire(context.Background(), rkey, m.ttl(validators != nil))
+		if source != "" {
+			// The set outlives every variant it references
+			pipe.SAdd(context.Background(), m.sourceKey(source), rkey)
+			pipe.Expire(context.Background(), m.sourceKey(source), m.ttl(true))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return NewEntry(img, format, now, source, validators), nil
+}
+
+// Stale returns an entry that can be revalidated against its upstream original regardless of its age, or nil.
+func (m *RedisManager) Stale(key string, format string) *Entry {
+	entry := m.read(key, format)
+	if entry == nil || entry.Validators == nil {
+		return nil
+	}
+	return entry
+}
+
+// Refresh marks an entry as fresh again after its upstream original was revalidated as unchanged.
+func (m *RedisManager) Refresh(key string, format string) error {
+	rkey := m.key(key, format)
+
+	fields, err := m.client.HMGet(context.Background(), rkey, "mod_time", "source").Result()
+	if err != nil {
+		return err
+	}
+	if fields[0] == nil {
+		return fmt.Errorf("cache entry not found: %s", rkey)
+	}
+
+	// Only entries with validators are revalidated
+	_, err = m.client.TxPipelined(context.Background(), func(pipe redis.Pipeliner) error {
+		pipe.HSet(context.Background(), rkey, "mod_time", time.Now().UnixNano())
+		pipe.Expire(context.Background(), rkey, m.ttl(true))
+		if source, ok := fields[1].(string); ok {
+			pipe.Expire(context.Background(), m.sourceKey(source), m.ttl(true))
+		}
+		return nil
+	})
+	return err
+}
+
+// Purge removes every entry derived from the source, returning how many were removed.
+func (m *RedisManager) Purge(source string) (int, error) {
+	skey := m.sourceKey(source)
+
+	keys, err := m.client.SMembers(context.Background(), skey).Result()
+	if err != nil {
+		return 0, err
+	}
+
+	// Keys are deleted one by one as they may live in different cluster slots
+	deletes := make([]*redis.IntCmd, len(keys))
+	_, err = m.client.Pipelined(context.Background(), func(pipe redis.Pipeliner) error {
+		for i, key := range keys {
+			deletes[i] = pipe.Del(context.Background(), key)
+		}
+		pipe.Del(context.Background(), skey)
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	removed := 0
+	for _, cmd := range deletes {
+		removed += int(cmd.Val())
+	}
+	return removed, nil
+}
+
+// Close closes the Redis client.
+func (m *RedisManager) Close() error {
+	return m.client.Close()
+}
@@ -0,0 +1,145 @@
+package managers
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewRedisManager(t *testing.T) {
+	t.Parallel()
+
+	_, err := NewRedisManager(nil)
+	assert.Equal(t, "RedisManager config is nil!", err.Error())
+
+	_, err = NewRedisManager(&RedisConfig{})
+	assert.Equal(t, "cfg.Client is nil!", err.Error())
+}
+
+func TestRedisManager(t *testing.T) {
+	t.Parallel()
+
+	server := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+
+	manager, err := NewRedisManager(&RedisConfig{Client: client, MaxAge: 60})
+	require.NoError(t, err)
+	defer manager.Close()
+
+	validators := &Validators{ETag: `"abc"`, LastModified: time.Unix(1445412480, 0).UTC()}
+
+	// Ages the entry past its max age without waiting
+	expire := func(key string) {
+		modTime := time.Now().Add(-2 * time.Minute).UnixNano()
+		server.HSet(DefaultRedisPrefix+key, "mod_time", strconv.FormatInt(modTime, 10))
+	}
+
+	t.Run("Get and Set", func(t *testing.T) {
+		assert.Nil(t, manager.Get("missing", "jpeg"))
+
+		entry, err := manager.Set("plain", []byte("test image data"), "jpeg", "", nil)
+		require.NoError(t, err)
+		assert.Nil(t, entry.Validators)
+
+		cached := manager.Get("plain", "jpeg")
+		if assert.NotNil(t, cached) {
+			assert.Equal(t, []byte("test image data"), readData(t, cached))
+			assert.Nil(t, cached.Validators)
+		}
+
+		// Formats are cached separately
+		assert.Nil(t, manager.Get("plain", "webp"))
+		assert.Equal(t, 60*time.Second, server.TTL(DefaultRedisPrefix+"plain.jpeg"))
+	})
+
+	t.Run("Expiration", func(t *testing.T) {
+		_, err := manager.Set("expiretest", []byte("test image data"), "jpeg", "", nil)
+		require.NoError(t, err)
+
+		expire("expiretest.jpeg")
+		assert.Nil(t, manager.Get("expiretest", "jpeg"))
+		assert.Nil(t, manager.Stale("expiretest", "jpeg"), "entries without validators can't be revalidated")
+
+		// Redis removes the entry once its TTL passes
+		server.FastForward(61 * time.Second)
+		assert.False(t, server.Exists(DefaultRedisPrefix+"expiretest.jpeg"))
+	})
+
+	t.Run("Revalidation", func(t *testing.T) {
+		_, err := manager.Set("revalidate", []byte("test image data"), "jpeg", "", validators)
+		require.NoError(t, err)
+		assert.Equal(t, 120*time.Second, server.TTL(DefaultRedisPrefix+"revalidate.jpeg"))
+
+		expire("revalidate.jpeg")
+		assert.Nil(t, manager.Get("revalidate", "jpeg"))
+
+		stale := manager.Stale("revalidate", "jpeg")
+		if assert.NotNil(t, stale) {
+			assert.Equal(t, []byte("test image data"), readData(t, stale))
+			assert.Equal(t, validators, stale.Validators)
+		}
+
+		require.NoError(t, manager.Refresh("revalidate", "jpeg"))
+		assert.NotNil(t, manager.Get("revalidate", "jpeg"))
+
+		// Replacing the entry drops the validators of the previous version
+		_, err = manager.Set("revalidate", []byte("test image data"), "jpeg", "", nil)
+		require.NoError(t, err)
+		assert.Nil(t, manager.Stale("revalidate", "jpeg"))
+
+		assert.Error(t, manager.Refresh("missing", "jpeg"))
+	})
+
+	t.Run("Shared between replicas", func(t *testing.T) {
+		replica, err := NewRedisManager(&RedisConfig{Client: redis.NewClient(&redis.Options{Addr: server.Addr()}), MaxAge: 60})
+		require.NoError(t, err)
+		defer replica.Close()
+
+		_, err = manager.Set("shared", []byte("test image data"), "jpeg", "", validators)
+		require.NoError(t, err)
+
+		cached := replica.Get("shared", "jpeg")
+		if assert.NotNil(t, cached) {
+			assert.Equal(t, []byte("test image data"), readData(t, cached))
+		}
+	})
+
+	t.Run("Purge", func(t *testing.T) {
+		for _, key := range []string{"small", "large"} {
+			_, err := manager.Set(key, []byte("test image data"), "jpeg", "http://imgur.com/image.jpg", validators)
+			require.NoError(t, err)
+		}
+		_, err := manager.Set("other", []byte("test image data"), "jpeg", "http://imgur.com/other.jpg", nil)
+		require.NoError(t, err)
+
+		cached := manager.Get("small", "jpeg")
+		if assert.NotNil(t, cached) {
+			assert.Equal(t, "http://imgur.com/image.jpg", cached.Source)
+		}
+
+		purged, err := manager.Purge("http://imgur.com/image.jpg")
+		assert.NoError(t, err)
+		assert.Equal(t, 2, purged)
+		assert.Nil(t, manager.Get("small", "jpeg"))
+		assert.Nil(t, manager.Get("large", "jpeg"))
+		assert.Nil(t, manager.Stale("large", "jpeg"))
+		assert.NotNil(t, manager.Get("other", "jpeg"))
+		assert.False(t, server.Exists(manager.sourceKey("http://imgur.com/image.jpg")))
+	})
+
+	t.Run("Unavailable", func(t *testing.T) {
+		unavailable, err := NewRedisManager(&RedisConfig{Client: redis.NewClient(&redis.Options{Addr: "127.0.0.1:1"})})
+		require.NoError(t, err)
+		defer unavailable.Close()
+
+		// Errors are treated as misses so requests fall back to processing the image
+		assert.Nil(t, unavailable.Get("plain", "jpeg"))
+		_, err = unavailable.Set("plain", []byte("test image data"), "jpeg", "", nil)
+		assert.Error(t, err)
+	})
+}
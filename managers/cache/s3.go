@@ -0,0 +1,222 @@
+package managers
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"antman-proxy/clients/s3"
+)
+
+type S3Config struct {
+	Client *s3.Client
+	Prefix string // Prepended to every object key, e.g. cache/
+	MaxAge int64
+}
+
+// S3Manager stores entries in an S3-compatible bucket so they're shared by every replica. The modification time and
+// validators of an entry are kept in the object metadata. Buckets should have a lifecycle rule expiring old objects,
+// expired entries without validators are only removed when they're read.
+type S3Manager struct {
+	client *s3.Client
+	prefix string
+	maxAge int64
+}
+
+func NewS3Manager(cfg *S3Config) (*S3Manager, error) {
+	if cfg == nil {
+		return nil, fmt.Errorf("S3Manager config is nil!")
+	}
+
+	if cfg.Client == nil {
+		return nil, fmt.Errorf("cfg.Client is nil!")
+	}
+
+	if cfg.MaxAge == 0 {
+		cfg.MaxAge = DefaultMaxAge
+	}
+
+	return &S3Manager{
+		client: cfg.Client,
+		prefix: cfg.Prefix,
+		maxAge: cfg.MaxAge,
+	}, nil
+}
+
+func (m *S3Manager) key(key string, format string) string {
+	ext := "jpg"
+	if format != "jpeg" {
+		ext = format
+	}
+	return fmt.Sprintf("%s%s.%s", m.prefix, key, ext)
+}
+
+// Variants derived from a source are tracked with empty marker objects named after them, listed when purging.
+func (m *S3Manager) sourcePrefix(source string) string {
+	return m.prefix + "sources/" + hashData([]byte(source)) + "/"
+}
+
+func encodeMetadata(modTime time.Time, source string, validators *Validators) map[string]string {
+	metadata := map[string]string{
+		"mod-time": strconv.FormatInt(modTime.UnixNano(), 10),
+	}
+	if source != "" {
+		// Header values must be ASCII
+		metadata["source"] = url.QueryEscape(source)
+	}
+	if validators != nil {
+		if validators.ETag != "" {
+			metadata["etag"] = validators.ETag
+		}
+		if !validators.LastModified.IsZero() {
+			metadata["last-modified"] = strconv.FormatInt(validators.LastModified.Unix(), 10)
+		}
+	}
+	return metadata
+}
+
+func decodeMetadata(metadata map[string]string) (time.Time, string, *Validators, error) {
+	modTime, err := strconv.ParseInt(metadata["mod-time"], 10, 64)
+	if err != nil {
+		return time.Time{}, "", nil, fmt.Errorf("invalid mod-time metadata: %w", err)
+	}
+
+	source, err := url.QueryUnescape(metadata["source"])
+	if err != nil {
+		return time.Time{}, "", nil, fmt.Errorf("invalid source metadata: %w", err)
+	}
+
+	validators := &Validators{ETag: metadata["etag"]}
+	if lastModified, err := strconv.ParseInt(metadata["last-modified"], 10, 64); err == nil {
+		validators.LastModified = time.Unix(lastModified, 0).UTC()
+	}
+	if validators.isZero() {
+		validators = nil
+	}
+
+	return time.Unix(0, modTime), source, validators, nil
+}
+
+func (m *S3Manager) read(key string, format string) *Entry {
+	resp, err := m.client.GetObject(m.key(key, format))
+	if err != nil {
+		if !errors.Is(err, s3.ErrNotFound) {
+			log.Println(err)
+		}
+		return nil
+	}
+	defer resp.Body.Close()
+
+	modTime, source, validators, err := decodeMetadata(s3.Metadata(resp.Header))
+	if err != nil {
+		log.Println(err)
+		return nil
+	}
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		log.Println(err)
+		return nil
+	}
+
+	return NewEntry(data, format, modTime, source, validators)
+}
+
+// Get returns a fresh cache entry, or nil. Expired entries are removed unless they can be revalidated against their
+// upstream original.
+func (m *S3Manager) Get(key string, format string) *Entry {
+	entry := m.read(key, format)
+	if entry == nil {
+		return nil
+	}
+
+	if time.Now().Unix()-entry.CreatedAt.Unix() > m.maxAge {
+		if entry.Validators == nil {
+			err := m.client.DeleteObject(m.key(key, format))
+			if err != nil {
+				log.Println(err)
+			}
+		}
+		return nil
+	}
+
+	return entry
+}
+
+func (m *S3Manager) Set(key string, img []byte, format string, source string, validators *Validators) (*Entry, error) {
+	if validators.isZero() {
+		validators = nil
+	}
+
+	now := time.Now()
+	objectKey := m.key(key, format)
+	err := m.client.PutObject(objectKey, img, ContentType(format), encodeMetadata(now, source, validators))
+	if err != nil {
+		return nil, err
+	}
+
+	if source != "" {
+		err = m.client.PutObject(m.sourcePrefix(source)+strings.TrimPrefix(objectKey, m.prefix), nil, "", nil)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return NewEntry(img, format, now, source, validators), nil
+}
+
+// Stale returns an entry that can be revalidated against its upstream original regardless of its age, or nil.
+func (m *S3Manager) Stale(key string, format string) *Entry {
+	entry := m.read(key, format)
+	if entry == nil || entry.Validators == nil {
+		return nil
+	}
+	return entry
+}
+
+// Refresh marks an entry as fresh again after its upstream original was revalidated as unchanged. The object is
+// copied onto itself with new metadata, so the image isn't uploaded again.
+func (m *S3Manager) Refresh(key string, format string) error {
+	objectKey := m.key(key, format)
+
+	header, err := m.client.HeadObject(objectKey)
+	if err != nil {
+		return err
+	}
+
+	_, source, validators, err := decodeMetadata(s3.Metadata(header))
+	if err != nil {
+		return err
+	}
+
+	return m.client.CopyObject(objectKey, objectKey, encodeMetadata(time.Now(), source, validators))
+}
+
+// Purge removes every entry derived from the source along with its marker, returning how many were removed.
+func (m *S3Manager) Purge(source string) (int, error) {
+	prefix := m.sourcePrefix(source)
+
+	markers, err := m.client.ListObjects(prefix)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, marker := range markers {
+		err = m.client.DeleteObject(m.prefix + strings.TrimPrefix(marker, prefix))
+		if err != nil {
+			return 0, err
+		}
+
+		err = m.client.DeleteObject(marker)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	return len(markers), nil
+}
@@ -0,0 +1,126 @@
+package managers
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"antman-proxy/clients/s3"
+	"antman-proxy/clients/s3/s3test"
+)
+
+func TestNewS3Manager(t *testing.T) {
+	t.Parallel()
+
+	_, err := NewS3Manager(nil)
+	assert.Equal(t, "S3Manager config is nil!", err.Error())
+
+	_, err = NewS3Manager(&S3Config{})
+	assert.Equal(t, "cfg.Client is nil!", err.Error())
+}
+
+func TestS3Manager(t *testing.T) {
+	t.Parallel()
+
+	server := s3test.NewServer()
+	defer server.Close()
+
+	client, err := s3.NewClient(&s3.Config{Endpoint: server.URL, Bucket: "cache", AccessKey: "access", SecretKey: "secret"})
+	require.NoError(t, err)
+
+	manager, err := NewS3Manager(&S3Config{Client: client, Prefix: "variants/", MaxAge: 60})
+	require.NoError(t, err)
+
+	validators := &Validators{ETag: `"abc"`, LastModified: time.Unix(1445412480, 0).UTC()}
+
+	// Ages the entry past its max age without waiting
+	expire := func(key string) {
+		object := server.Object("cache", "variants/"+key)
+		require.NotNil(t, object)
+		object.Metadata["mod-time"] = strconv.FormatInt(time.Now().Add(-2*time.Minute).UnixNano(), 10)
+	}
+
+	t.Run("Get and Set", func(t *testing.T) {
+		assert.Nil(t, manager.Get("missing", "jpeg"))
+
+		_, err := manager.Set("plain", []byte("test image data"), "jpeg", "", nil)
+		require.NoError(t, err)
+
+		object := server.Object("cache", "variants/plain.jpg")
+		if assert.NotNil(t, object) {
+			assert.Equal(t, "image/jpeg", object.ContentType)
+		}
+
+		cached := manager.Get("plain", "jpeg")
+		if assert.NotNil(t, cached) {
+			assert.Equal(t, []byte("test image data"), readData(t, cached))
+			assert.Nil(t, cached.Validators)
+		}
+	})
+
+	t.Run("Expiration", func(t *testing.T) {
+		_, err := manager.Set("expiretest", []byte("test image data"), "jpeg", "", nil)
+		require.NoError(t, err)
+
+		expire("expiretest.jpg")
+		assert.Nil(t, manager.Get("expiretest", "jpeg"))
+		assert.Nil(t, server.Object("cache", "variants/expiretest.jpg"), "expired entries without validators should be removed")
+	})
+
+	t.Run("Revalidation", func(t *testing.T) {
+		_, err := manager.Set("revalidate", []byte("test image data"), "jpeg", "", validators)
+		require.NoError(t, err)
+
+		expire("revalidate.jpg")
+		assert.Nil(t, manager.Get("revalidate", "jpeg"))
+
+		stale := manager.Stale("revalidate", "jpeg")
+		if assert.NotNil(t, stale) {
+			assert.Equal(t, []byte("test image data"), readData(t, stale))
+			assert.Equal(t, validators, stale.Validators)
+		}
+
+		puts := server.Requests("PUT")
+		require.NoError(t, manager.Refresh("revalidate", "jpeg"))
+		assert.Equal(t, puts+1, server.Requests("PUT"))
+
+		refreshed := manager.Get("revalidate", "jpeg")
+		if assert.NotNil(t, refreshed) {
+			assert.Equal(t, []byte("test image data"), readData(t, refreshed))
+			assert.Equal(t, validators, refreshed.Validators)
+		}
+
+		assert.ErrorIs(t, manager.Refresh("missing", "jpeg"), s3.ErrNotFound)
+	})
+
+	t.Run("Purge", func(t *testing.T) {
+		// Sources aren't restricted to ASCII
+		source := "http://imgur.com/café.jpg"
+		for _, key := range []string{"small", "large", "medium"} {
+			_, err := manager.Set(key, []byte("test image data"), "jpeg", source, validators)
+			require.NoError(t, err)
+		}
+		_, err := manager.Set("other", []byte("test image data"), "jpeg", "http://imgur.com/other.jpg", nil)
+		require.NoError(t, err)
+
+		cached := manager.Get("small", "jpeg")
+		if assert.NotNil(t, cached) {
+			assert.Equal(t, source, cached.Source)
+		}
+
+		purged, err := manager.Purge(source)
+		assert.NoError(t, err)
+		assert.Equal(t, 3, purged)
+		for _, key := range []string{"small", "large", "medium"} {
+			assert.Nil(t, server.Object("cache", "variants/"+key+".jpg"))
+		}
+		assert.NotNil(t, manager.Get("other", "jpeg"))
+
+		markers, err := client.ListObjects(manager.sourcePrefix(source))
+		assert.NoError(t, err)
+		assert.Empty(t, markers)
+	})
+}
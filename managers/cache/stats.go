@@ -0,0 +1,77 @@
+package managers
+
+import (
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// AgeBuckets are the upper bounds of the buckets of the age distribution of cached entries.
+var AgeBuckets = []time.Duration{time.Minute, time.Hour, 6 * time.Hour, 24 * time.Hour, 7 * 24 * time.Hour}
+
+// Stats describes how effective a cache is. Counters are reset when the process restarts.
+type Stats struct {
+	Hits        int64                  `json:"hits"`
+	Misses      int64                  `json:"misses"`
+	Expirations int64                  `json:"expirations"` // Expired entries removed by lookups or the janitor
+	Evictions   int64                  `json:"evictions"`   // Entries removed to stay within the bounds
+	Bytes       int64                  `json:"bytes"`
+	Entries     int                    `json:"entries"`
+	Formats     map[string]FormatStats `json:"formats"`
+	Ages        []AgeBucket            `json:"ages"`            // Cumulative, entries older than the last bucket are only counted by Entries
+	AgeSum      float64                `json:"age_sum_seconds"` // Sum of the ages of every entry
+}
+
+type FormatStats struct {
+	Bytes   int64 `json:"bytes"`
+	Entries int   `json:"entries"`
+}
+
+// AgeBucket counts the entries created or revalidated at most MaxAge seconds ago.
+type AgeBucket struct {
+	MaxAge  float64 `json:"max_age_seconds"`
+	Entries int     `json:"entries"`
+}
+
+// StatsReporter is implemented by the managers that keep statistics.
+type StatsReporter interface {
+	Stats() Stats
+}
+
+func newStats() Stats {
+	stats := Stats{
+		Formats: map[string]FormatStats{},
+		Ages:    make([]AgeBucket, len(AgeBuckets)),
+	}
+	for i, bound := range AgeBuckets {
+		stats.Ages[i].MaxAge = bound.Seconds()
+	}
+	return stats
+}
+
+// Accounts for a stored entry.
+func (s *Stats) add(format string, size int64, age time.Duration) {
+	s.Bytes += size
+	s.Entries++
+
+	formatStats := s.Formats[format]
+	formatStats.Bytes += size
+	formatStats.Entries++
+	s.Formats[format] = formatStats
+
+	s.AgeSum += age.Seconds()
+	for i, bound := range AgeBuckets {
+		if age <= bound {
+			s.Ages[i].Entries++
+		}
+	}
+}
+
+// Returns the format of the entry stored at path, the inverse of GetPath.
+func formatOf(path string) string {
+	ext := strings.TrimPrefix(filepath.Ext(path), ".")
+	if ext == "jpg" {
+		return "jpeg"
+	}
+	return ext
+}
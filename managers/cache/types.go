@@ -1,7 +1,82 @@
 package managers
 
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"time"
+)
+
+// Validators of the upstream original a cached entry was derived from, used to revalidate expired entries.
+type Validators struct {
+	ETag         string    `json:"etag,omitempty"`
+	LastModified time.Time `json:"last_modified,omitempty"`
+}
+
+// Equal reports whether both validators identify the same version of the upstream original.
+func (v *Validators) Equal(other *Validators) bool {
+	if v == nil || other == nil {
+		return v == other
+	}
+	return v.ETag == other.ETag && v.LastModified.Equal(other.LastModified)
+}
+
+// Entry is a cached image variant. The caller owns Body and must Close the entry once it has been served.
+type Entry struct {
+	Body        io.ReadSeeker
+	Size        int64
+	ContentType string
+	CreatedAt   time.Time // When the entry was stored or last revalidated
+	Hash        string    // Hex encoded SHA-256 of the content
+	Source      string    // Image the entry was derived from, used to purge every variant of it
+	Validators  *Validators
+}
+
+// NewEntry returns an entry reading from data.
+func NewEntry(data []byte, format string, createdAt time.Time, source string, validators *Validators) *Entry {
+	return &Entry{
+		Body:        bytes.NewReader(data),
+		Size:        int64(len(data)),
+		ContentType: ContentType(format),
+		CreatedAt:   createdAt,
+		Hash:        hashData(data),
+		Source:      source,
+		Validators:  validators,
+	}
+}
+
+// Close releases the resources held by the body of the entry, if any.
+func (e *Entry) Close() error {
+	if closer, ok := e.Body.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+// ReadAll reads the whole content of the entry from its start.
+func (e *Entry) ReadAll() ([]byte, error) {
+	_, err := e.Body.Seek(0, io.SeekStart)
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(e.Body)
+}
+
+// ContentType returns the MIME type of an output format.
+func ContentType(format string) string {
+	return "image/" + format
+}
+
+func hashData(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
 type Manager interface {
-	Get(key string, format string) string
-	Set(key string, img []byte, format string) (string, error)
-	GetPath(key string, format string) string
+	Get(key string, format string) *Entry
+	Set(key string, img []byte, format string, source string, validators *Validators) (*Entry, error)
+	Stale(key string, format string) *Entry
+	Refresh(key string, format string) error
+	Purge(source string) (int, error)
 }
@@ -0,0 +1,69 @@
+package managers
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"image"
+	"image/color"
+	"io"
+)
+
+// DefaultMaxPixels bounds the originals that are decoded, a 50 megapixel RGBA image takes 200MB once decoded.
+const DefaultMaxPixels = 50_000_000
+
+var (
+	// ErrTooManyPixels is returned for originals or variants whose dimensions exceed the pixel limit, decompression
+	// bombs among them.
+	ErrTooManyPixels = errors.New("image has too many pixels")
+	// ErrTooMuchMemory is wrapped by the errors of reservations larger than the whole memory budget.
+	ErrTooMuchMemory = errors.New("image needs more memory than the budget")
+)
+
+// MemoryBudget bounds the memory used by images being processed at once.
+type MemoryBudget interface {
+	// Reserve blocks until size bytes are available or ctx is done. release returns them to the budget. Sizes that
+	// can never be available fail right away with an error wrapping ErrTooMuchMemory.
+	Reserve(ctx context.Context, size int64) (release func(), err error)
+}
+
+// Reads the header of the encoded image, returning its config along with a reader of the whole image.
+func decodeConfig(r io.Reader) (image.Config, io.Reader, error) {
+	header := new(bytes.Buffer)
+	config, _, err := image.DecodeConfig(io.TeeReader(r, header))
+	return config, io.MultiReader(header, r), err
+}
+
+// Returns how many bytes a pixel takes once an image of the color model is decoded.
+func bytesPerPixel(model color.Model) int64 {
+	switch model {
+	case color.GrayModel, color.AlphaModel:
+		return 1
+	case color.Gray16Model, color.Alpha16Model:
+		return 2
+	case color.RGBA64Model, color.NRGBA64Model:
+		return 8
+	case color.YCbCrModel:
+		return 3 // Without chroma subsampling, the worst case
+	default:
+		// Paletted models are their own type and take a byte per pixel
+		if _, ok := model.(color.Palette); ok {
+			return 1
+		}
+		return 4
+	}
+}
+
+// Estimates the memory needed to decode the original described by config and resize it to width by height.
+func estimateMemory(config image.Config, width, height int) int64 {
+	decoded := int64(config.Width) * int64(config.Height) * bytesPerPixel(config.ColorModel)
+
+	// Resizing produces 16 bit images from 16 bit originals, 8 bit images otherwise
+	resizedPixel := int64(4)
+	if bytesPerPixel(config.ColorModel) > 4 {
+		resizedPixel = 8
+	}
+	resized := int64(width) * int64(height) * resizedPixel
+
+	return decoded + resized
+}
@@ -0,0 +1,83 @@
+package managers
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDecodeConfig(t *testing.T) {
+	encoded := new(bytes.Buffer)
+	require.NoError(t, png.Encode(encoded, image.NewGray16(image.Rect(0, 0, 30, 20))))
+
+	config, body, err := decodeConfig(bytes.NewReader(encoded.Bytes()))
+	require.NoError(t, err)
+	assert.Equal(t, 30, config.Width)
+	assert.Equal(t, 20, config.Height)
+
+	// The header read for the config is still part of the image
+	data, err := io.ReadAll(body)
+	require.NoError(t, err)
+	assert.Equal(t, encoded.Bytes(), data)
+
+	_, _, err = decodeConfig(bytes.NewReader([]byte("not an image")))
+	assert.ErrorIs(t, err, image.ErrFormat)
+}
+
+func TestEstimateMemory(t *testing.T) {
+	tests := []struct {
+		name     string
+		config   image.Config
+		width    int
+		height   int
+		expected int64
+	}{
+		{
+			name:     "rgba",
+			config:   image.Config{ColorModel: color.RGBAModel, Width: 1000, Height: 500},
+			width:    100,
+			height:   50,
+			expected: 1000*500*4 + 100*50*4,
+		},
+		{
+			name:     "gray",
+			config:   image.Config{ColorModel: color.GrayModel, Width: 1000, Height: 500},
+			width:    100,
+			height:   50,
+			expected: 1000*500 + 100*50*4,
+		},
+		{
+			name:     "paletted",
+			config:   image.Config{ColorModel: color.Palette{color.Black, color.White}, Width: 1000, Height: 500},
+			width:    100,
+			height:   50,
+			expected: 1000*500 + 100*50*4,
+		},
+		{
+			name:     "16 bit",
+			config:   image.Config{ColorModel: color.NRGBA64Model, Width: 1000, Height: 500},
+			width:    100,
+			height:   50,
+			expected: 1000*500*8 + 100*50*8,
+		},
+		{
+			name:     "decompression bomb",
+			config:   image.Config{ColorModel: color.NRGBAModel, Width: 100000, Height: 100000},
+			width:    100,
+			height:   100,
+			expected: 100000*100000*4 + 100*100*4,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, estimateMemory(tt.config, tt.width, tt.height))
+		})
+	}
+}
@@ -0,0 +1,146 @@
+package managers
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"sync"
+
+	cacheManager "antman-proxy/managers/cache"
+)
+
+// flight is a processing of a variant in progress, or completed.
+type flight struct {
+	done     chan struct{}
+	cancel   context.CancelFunc
+	waiting  int  // Callers waiting for the result, guarded by the group lock
+	finished bool // Guarded by the group lock
+	taken    bool // Whether a caller took the entry itself, guarded by the group lock
+	entry    *cacheManager.Entry
+	data     []byte // Content of the entry, buffered only when several callers are waiting for it
+	err      error
+}
+
+// flightGroup coalesces concurrent calls for the same key so the work is done once, while calls for different keys
+// run in parallel.
+type flightGroup struct {
+	mu      sync.Mutex
+	flights map[string]*flight
+}
+
+func newFlightGroup() *flightGroup {
+	return &flightGroup{
+		flights: map[string]*flight{},
+	}
+}
+
+// do runs fn for the key unless it is already running, in which case it waits for and shares its result. Every
+// caller gets an entry with its own body. A caller whose ctx is canceled stops waiting right away, while the work
+// itself is only canceled once every caller waiting for it is gone. The last caller to leave waits for fn to return
+// before doing so, fn may not stop decoding when canceled, so the work running at once stays bounded by the callers,
+// such as the workers of a pool.
+func (g *flightGroup) do(ctx context.Context, key string, fn func(ctx context.Context) (*cacheManager.Entry, error)) (*cacheManager.Entry, error) {
+	g.mu.Lock()
+	f, ok := g.flights[key]
+	if !ok {
+		// The work outlives the caller that started it as long as others are waiting
+		flightCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+		f = &flight{done: make(chan struct{}), cancel: cancel}
+		g.flights[key] = f
+		go g.run(flightCtx, key, f, fn)
+	}
+	f.waiting++
+	g.mu.Unlock()
+
+	select {
+	case <-f.done:
+		return g.take(f)
+	case <-ctx.Done():
+	}
+
+	g.mu.Lock()
+	f.waiting--
+	finished := f.finished
+	last := f.waiting == 0 && !finished
+	if last {
+		f.cancel()
+	}
+	g.mu.Unlock()
+
+	switch {
+	case finished:
+		// The result arrived while giving up, it is released since no one else may be waiting for it
+		entry, err := g.take(f)
+		if err == nil {
+			entry.Close()
+		}
+	case last:
+		// The work is no one's anymore once it stops, run releases its result
+		<-f.done
+	}
+
+	return nil, ctx.Err()
+}
+
+func (g *flightGroup) run(ctx context.Context, key string, f *flight, fn func(ctx context.Context) (*cacheManager.Entry, error)) {
+	entry, err := call(ctx, fn)
+	f.cancel()
+
+	// No one can join the flight once it's removed, so waiting only decreases from now on
+	g.mu.Lock()
+	delete(g.flights, key)
+	f.finished = true
+	waiting := f.waiting
+	g.mu.Unlock()
+
+	switch {
+	case err != nil:
+	case waiting == 0:
+		entry.Close()
+		entry = nil
+	case waiting > 1:
+		// Every caller gets its own reader over the buffered content
+		f.data, err = entry.ReadAll()
+		entry.Close()
+	}
+
+	f.entry, f.err = entry, err
+	close(f.done)
+}
+
+// Calls fn, turning a panic into an error. The flight runs on its own goroutine, out of reach of the recover of the
+// callers, so a panic would otherwise crash the server.
+func call(ctx context.Context, fn func(ctx context.Context) (*cacheManager.Entry, error)) (entry *cacheManager.Entry, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("Recovered from panic in flight: %v", r)
+			entry, err = nil, fmt.Errorf("processing panicked: %v", r)
+		}
+	}()
+
+	return fn(ctx)
+}
+
+// Returns the result of a finished flight. The first caller takes the entry itself unless its content was buffered.
+func (g *flightGroup) take(f *flight) (*cacheManager.Entry, error) {
+	<-f.done
+	if f.err != nil {
+		return nil, f.err
+	}
+
+	if f.data == nil {
+		g.mu.Lock()
+		taken := f.taken
+		f.taken = true
+		g.mu.Unlock()
+
+		if !taken {
+			return f.entry, nil
+		}
+	}
+
+	entry := *f.entry
+	entry.Body = bytes.NewReader(f.data)
+	return &entry, nil
+}
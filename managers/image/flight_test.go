@@ -0,0 +1,227 @@
+package managers
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	cacheManager "antman-proxy/managers/cache"
+)
+
+func TestFlightGroup_do(t *testing.T) {
+	t.Parallel()
+
+	t.Run("waiters get their own body", func(t *testing.T) {
+		group := newFlightGroup()
+		release := make(chan struct{})
+
+		var wg sync.WaitGroup
+		entries := make([]*cacheManager.Entry, 3)
+		for i := range entries {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				entry, err := group.do(context.Background(), "key", func(ctx context.Context) (*cacheManager.Entry, error) {
+					<-release
+					return newEntry([]byte("processed image")), nil
+				})
+				if assert.NoError(t, err) {
+					entries[i] = entry
+				}
+			}(i)
+		}
+
+		assert.Eventually(t, func() bool {
+			group.mu.Lock()
+			defer group.mu.Unlock()
+			return group.flights["key"] != nil && group.flights["key"].waiting == len(entries)
+		}, time.Second, time.Millisecond)
+		close(release)
+		wg.Wait()
+
+		for _, entry := range entries {
+			assert.Equal(t, []byte("processed image"), readData(t, entry))
+		}
+		assert.Empty(t, group.flights)
+	})
+
+	t.Run("errors are shared", func(t *testing.T) {
+		group := newFlightGroup()
+		release := make(chan struct{})
+		expected := errors.New("processing failed")
+
+		var wg sync.WaitGroup
+		for i := 0; i < 2; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				_, err := group.do(context.Background(), "key", func(ctx context.Context) (*cacheManager.Entry, error) {
+					<-release
+					return nil, expected
+				})
+				assert.Equal(t, expected, err)
+			}()
+		}
+
+		assert.Eventually(t, func() bool {
+			group.mu.Lock()
+			defer group.mu.Unlock()
+			return group.flights["key"] != nil && group.flights["key"].waiting == 2
+		}, time.Second, time.Millisecond)
+		close(release)
+		wg.Wait()
+	})
+
+	t.Run("panics are shared as errors", func(t *testing.T) {
+		group := newFlightGroup()
+		release := make(chan struct{})
+
+		var wg sync.WaitGroup
+		for i := 0; i < 2; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				_, err := group.do(context.Background(), "key", func(ctx context.Context) (*cacheManager.Entry, error) {
+					<-release
+					panic("makeslice: len out of range")
+				})
+				assert.ErrorContains(t, err, "processing panicked: makeslice: len out of range")
+			}()
+		}
+
+		assert.Eventually(t, func() bool {
+			group.mu.Lock()
+			defer group.mu.Unlock()
+			return group.flights["key"] != nil && group.flights["key"].waiting == 2
+		}, time.Second, time.Millisecond)
+
+		close(release)
+		wg.Wait()
+		assert.Empty(t, group.flights)
+	})
+
+	t.Run("work continues while a caller waits", func(t *testing.T) {
+		group := newFlightGroup()
+		release := make(chan struct{})
+		canceled := make(chan struct{})
+
+		fn := func(ctx context.Context) (*cacheManager.Entry, error) {
+			select {
+			case <-release:
+				return newEntry([]byte("processed image")), nil
+			case <-ctx.Done():
+				close(canceled)
+				return nil, ctx.Err()
+			}
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		left := make(chan error)
+		go func() {
+			_, err := group.do(ctx, "key", fn)
+			left <- err
+		}()
+
+		result := make(chan []byte)
+		go func() {
+			entry, err := group.do(context.Background(), "key", fn)
+			if assert.NoError(t, err) {
+				result <- readData(t, entry)
+			}
+		}()
+
+		assert.Eventually(t, func() bool {
+			group.mu.Lock()
+			defer group.mu.Unlock()
+			return group.flights["key"] != nil && group.flights["key"].waiting == 2
+		}, time.Second, time.Millisecond)
+
+		// The caller that started the work leaves, the other one still gets the result
+		cancel()
+		assert.ErrorIs(t, <-left, context.Canceled)
+
+		close(release)
+		assert.Equal(t, []byte("processed image"), <-result)
+		select {
+		case <-canceled:
+			t.Error("work was canceled while a caller was waiting")
+		default:
+		}
+	})
+
+	t.Run("work is canceled once every caller left", func(t *testing.T) {
+		group := newFlightGroup()
+		canceled := make(chan struct{})
+
+		ctx, cancel := context.WithCancel(context.Background())
+		var wg sync.WaitGroup
+		for i := 0; i < 2; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				_, err := group.do(ctx, "key", func(ctx context.Context) (*cacheManager.Entry, error) {
+					<-ctx.Done()
+					close(canceled)
+					return nil, ctx.Err()
+				})
+				assert.ErrorIs(t, err, context.Canceled)
+			}()
+		}
+
+		assert.Eventually(t, func() bool {
+			group.mu.Lock()
+			defer group.mu.Unlock()
+			return group.flights["key"] != nil && group.flights["key"].waiting == 2
+		}, time.Second, time.Millisecond)
+
+		cancel()
+		wg.Wait()
+
+		select {
+		case <-canceled:
+		case <-time.After(time.Second):
+			t.Fatal("work wasn't canceled")
+		}
+		assert.Eventually(t, func() bool {
+			group.mu.Lock()
+			defer group.mu.Unlock()
+			return len(group.flights) == 0
+		}, time.Second, time.Millisecond)
+	})
+
+	t.Run("last caller waits for the work to stop", func(t *testing.T) {
+		group := newFlightGroup()
+		started := make(chan struct{})
+		release := make(chan struct{})
+
+		// Decoding doesn't stop when canceled
+		fn := func(ctx context.Context) (*cacheManager.Entry, error) {
+			close(started)
+			<-release
+			return newEntry([]byte("processed image")), nil
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		left := make(chan error)
+		go func() {
+			_, err := group.do(ctx, "key", fn)
+			left <- err
+		}()
+
+		<-started
+		cancel()
+		select {
+		case <-left:
+			t.Fatal("caller left while the work was still running")
+		case <-time.After(50 * time.Millisecond):
+		}
+
+		close(release)
+		assert.ErrorIs(t, <-left, context.Canceled)
+		assert.Empty(t, group.flights)
+	})
+}
@@ -2,34 +2,52 @@ package managers
 
 import (
 	"bytes"
-	"crypto/md5"
+	"context"
+	"errors"
 	"fmt"
 	"image"
 	"image/jpeg"
 	"image/png"
-	"net/http"
 	"net/url"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/chai2010/webp"
 	"github.com/nfnt/resize"
 
 	cacheManager "antman-proxy/managers/cache"
+	"antman-proxy/sources"
 )
 
 const (
 	DefaultQualityPercent = 85
+	LocalOrigin           = "local" // Origin name of the local filesystem source addressed with the path parameter
 )
 
 type Config struct {
 	AllowedDomains []string
 	CacheManager   cacheManager.Manager
+	Origins        map[string]sources.Source       // Named origins addressed with name:key
+	Upstream       sources.Source                  // Source for absolute URLs, defaults to HTTP configured with Domains
+	Domains        map[string]*sources.FetchConfig // Per domain headers, credentials, timeouts and size limits
+	Local          sources.Source                  // Optional local filesystem source, registered as the LocalOrigin origin
+	SourceCache    cacheManager.Manager            // Optional cache of originals keyed by URL, so new variants aren't downloaded again
+	KeySalt        string                          // Mixed into every cache key, changing it invalidates every cached variant
+	MaxPixels      int64                           // Originals with more pixels aren't decoded, defaults to DefaultMaxPixels, negative for no limit
+	MemoryBudget   MemoryBudget                    // Optional, admits decodes by their estimated memory
 }
 
 type ImageManager struct {
 	allowedDomains []string
 	cacheManager   cacheManager.Manager
+	origins        map[string]sources.Source
+	upstream       sources.Source
+	sourceCache    cacheManager.Manager
+	keySalt        string
+	maxPixels      int64
+	memoryBudget   MemoryBudget
+	flights        *flightGroup
 	mu             sync.RWMutex
 }
 
@@ -46,17 +64,65 @@ func NewManager(cfg *Config) (*ImageManager, error) {
 		return nil, fmt.Errorf("cfg.CacheManager is nil!")
 	}
 
+	if cfg.Upstream == nil {
+		upstream, err := sources.NewHTTPSource(&sources.HTTPConfig{Domains: cfg.Domains})
+		if err != nil {
+			return nil, err
+		}
+		cfg.Upstream = upstream
+	}
+
+	origins := map[string]sources.Source{}
+	for name, origin := range cfg.Origins {
+		origins[name] = origin
+	}
+
+	if cfg.Local != nil {
+		if _, ok := origins[LocalOrigin]; ok {
+			return nil, fmt.Errorf("cfg.Origins already contains the %s origin!", LocalOrigin)
+		}
+		origins[LocalOrigin] = cfg.Local
+	}
+
+	if cfg.MaxPixels == 0 {
+		cfg.MaxPixels = DefaultMaxPixels
+	}
+
 	return &ImageManager{
 		allowedDomains: cfg.AllowedDomains,
 		cacheManager:   cfg.CacheManager,
+		origins:        origins,
+		upstream:       cfg.Upstream,
+		sourceCache:    cfg.SourceCache,
+		keySalt:        cfg.KeySalt,
+		maxPixels:      cfg.MaxPixels,
+		memoryBudget:   cfg.MemoryBudget,
+		flights:        newFlightGroup(),
 		mu:             sync.RWMutex{},
 	}, nil
 }
 
+// Resolves an image reference, either an origin reference in the form name:key or an absolute URL, to the
+// source it should be fetched from and the key within that source.
+func (m *ImageManager) resolveSource(imageURL string) (sources.Source, string, bool) {
+	name, key, ok := strings.Cut(imageURL, ":")
+	if ok {
+		if origin, ok := m.origins[name]; ok {
+			return origin, key, true
+		}
+	}
+	return m.upstream, imageURL, false
+}
+
 func (m *ImageManager) IsURLAllowed(imageURL string) bool {
 	m.mu.RLock()
 	defer m.mu.RUnlock()
 
+	// Named origins are trusted by configuration
+	if _, _, ok := m.resolveSource(imageURL); ok {
+		return true
+	}
+
 	parsedURL, err := url.Parse(imageURL)
 	if err != nil {
 		return false
@@ -72,8 +138,7 @@ func (m *ImageManager) IsURLAllowed(imageURL string) bool {
 }
 
 // Determine what the final dimensions should be accounting for original aspect ratio.
-func calculateDimensions(image image.Image, width, height int) (int, int) {
-	bounds := image.Bounds()
+func calculateDimensions(bounds image.Rectangle, width, height int) (int, int) {
 	originalWidth := bounds.Max.X - bounds.Min.X
 	originalHeight := bounds.Max.Y - bounds.Min.Y
 
@@ -87,63 +152,135 @@ func calculateDimensions(image image.Image, width, height int) (int, int) {
 	}
 }
 
-func (m *ImageManager) ProcessImage(imageURL string, width, height int, format string, quality int) (string, error) {
-	m.mu.Lock()
-	defer m.mu.Unlock()
-
-	cacheKey := m.generateCacheKey(imageURL, width, height, format)
+// ProcessImage returns the variant of the image, processing it on a cache miss. Canceling ctx stops waiting for the
+// variant, and stops processing it unless other callers are waiting for the same variant.
+func (m *ImageManager) ProcessImage(ctx context.Context, imageURL string, width, height int, format string, quality int) (*cacheManager.Entry, error) {
+	req := Request{URL: imageURL, Width: width, Height: height, Format: format, Quality: quality}
+	cacheKey := m.generateCacheKey(req)
 
 	cached := m.cacheManager.Get(cacheKey, format)
-	if cached != "" {
+	if cached != nil {
 		return cached, nil
 	}
 
-	resp, err := http.Get(imageURL)
+	// Concurrent misses for the same variant wait for a single fetch and encode
+	return m.flights.do(ctx, cacheKey, func(ctx context.Context) (*cacheManager.Entry, error) {
+		return m.process(ctx, cacheKey, req)
+	})
+}
+
+func (m *ImageManager) process(ctx context.Context, cacheKey string, req Request) (*cacheManager.Entry, error) {
+	imageURL, format := req.URL, req.Format
+
+	// Expired variants are revalidated against their original so unchanged images aren't downloaded and encoded again
+	var validators *cacheManager.Validators
+	stale := m.cacheManager.Stale(cacheKey, format)
+	if stale != nil {
+		validators = stale.Validators
+	}
+
+	original, err := m.fetchOriginal(ctx, imageURL, validators)
+	if errors.Is(err, sources.ErrNotModified) {
+		err = m.cacheManager.Refresh(cacheKey, format)
+		if err != nil {
+			stale.Close()
+			return nil, err
+		}
+
+		stale.CreatedAt = time.Now()
+		return stale, nil
+	}
+	if stale != nil {
+		stale.Close()
+	}
 	if err != nil {
-		return "", err
+		return nil, err
 	}
-	defer resp.Body.Close()
+	defer original.body.Close()
 
-	img, _, err := image.Decode(resp.Body)
+	// The dimensions in the header are checked before decoding allocates memory for every pixel
+	config, body, err := decodeConfig(original.body)
 	if err != nil {
-		return "", err
+		return nil, err
+	}
+	if pixels := int64(config.Width) * int64(config.Height); m.maxPixels > 0 && pixels > m.maxPixels {
+		return nil, fmt.Errorf("%w: %dx%d exceeds %d pixels", ErrTooManyPixels, config.Width, config.Height, m.maxPixels)
 	}
 
 	// @TODO: handle resizing by percentage, check cache key generation
-	finalWidth, finalHeight := calculateDimensions(img, height, width)
-	resizedImage := resize.Resize(uint(finalWidth), uint(finalHeight), img, resize.Lanczos3)
+	finalWidth, finalHeight := calculateDimensions(image.Rect(0, 0, config.Width, config.Height), req.Height, req.Width)
+
+	// Extreme aspect ratios turn small originals into huge variants
+	if pixels := int64(finalWidth) * int64(finalHeight); m.maxPixels > 0 && pixels > m.maxPixels {
+		return nil, fmt.Errorf("%w: resizing to %dx%d exceeds %d pixels", ErrTooManyPixels, finalWidth, finalHeight, m.maxPixels)
+	}
+
+	if m.memoryBudget != nil {
+		release, err := m.memoryBudget.Reserve(ctx, estimateMemory(config, finalWidth, finalHeight))
+		if err != nil {
+			return nil, err
+		}
+		defer release()
+	}
+
+	// Reading the original stops as soon as ctx is canceled, decoding, resizing and encoding can't be interrupted so
+	// ctx is checked between them
+	img, _, err := image.Decode(body)
+	if err != nil {
+		return nil, err
+	}
+	if err = ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	resizedImage := resize.Resize(uint(finalWidth), uint(finalHeight), img, interpolation)
+	if err = ctx.Err(); err != nil {
+		return nil, err
+	}
 
 	output := new(bytes.Buffer)
 
 	switch format {
 	case "jpeg":
-		err = jpeg.Encode(output, resizedImage, &jpeg.Options{Quality: quality})
+		err = jpeg.Encode(output, resizedImage, &jpeg.Options{Quality: req.Quality})
 		if err != nil {
-			return "", fmt.Errorf("jpeg.Encode: %s", err)
+			return nil, fmt.Errorf("jpeg.Encode: %s", err)
 		}
 	case "png":
 		err = png.Encode(output, resizedImage)
 		if err != nil {
-			return "", fmt.Errorf("png.Encode: %s", err)
+			return nil, fmt.Errorf("png.Encode: %s", err)
 		}
 	case "webp":
 		options := &webp.Options{
-			Lossless: false,
-			Quality:  float32(quality),
+			Lossless: webpLossless,
+			Quality:  float32(req.Quality),
 		}
 
 		err = webp.Encode(output, resizedImage, options)
 		if err != nil {
-			return "", fmt.Errorf("webp.Encode: %v", err)
+			return nil, fmt.Errorf("webp.Encode: %v", err)
 		}
 	default:
-		return "", fmt.Errorf("unsupported output format: %s", format)
+		return nil, fmt.Errorf("unsupported output format: %s", format)
+	}
+
+	return m.cacheManager.Set(cacheKey, output.Bytes(), format, imageURL, original.validators)
+}
+
+// Purge removes every cached variant of the image along with its cached original, so the next request derives them
+// from the current upstream version. Returns the number of variants removed.
+func (m *ImageManager) Purge(imageURL string) (int, error) {
+	if m.sourceCache != nil {
+		_, err := m.sourceCache.Purge(imageURL)
+		if err != nil {
+			return 0, err
+		}
 	}
 
-	return m.cacheManager.Set(cacheKey, output.Bytes(), format)
+	return m.cacheManager.Purge(imageURL)
 }
 
-func (m *ImageManager) generateCacheKey(url string, width, height int, format string) string {
-	data := fmt.Sprintf("%s_%d_%d_%s", url, width, height, format)
-	return fmt.Sprintf("%x", md5.Sum([]byte(data)))
+func (m *ImageManager) generateCacheKey(req Request) string {
+	return req.Key(m.keySalt)
 }
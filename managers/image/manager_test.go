@@ -1,13 +1,26 @@
 package managers
 
 import (
+	"bytes"
+	"context"
 	"image"
+	"image/png"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/golang/mock/gomock"
 	"github.com/stretchr/testify/assert"
 
+	cacheManager "antman-proxy/managers/cache"
 	cacheManagerMock "antman-proxy/managers/cache/mock_manager"
+	"antman-proxy/sources"
+	sourceMock "antman-proxy/sources/mock_source"
 )
 
 func getAllowedDomains() []string {
@@ -139,7 +152,7 @@ func TestImageManager_ProcessImage(t *testing.T) {
 		format        string
 		quality       int
 		expectedError bool
-		expectedPath  string
+		expectedData  []byte
 	}{
 		{
 			name:          "successful processing with cache hit",
@@ -149,7 +162,7 @@ func TestImageManager_ProcessImage(t *testing.T) {
 			format:        "jpeg",
 			quality:       50,
 			expectedError: false,
-			expectedPath:  "https://public-assets.getluna.com/images/social/facebook.png",
+			expectedData:  []byte("processed image"),
 		},
 		{
 			name:          "successful processing without cache hit",
@@ -159,7 +172,7 @@ func TestImageManager_ProcessImage(t *testing.T) {
 			format:        "jpeg",
 			quality:       50,
 			expectedError: false,
-			expectedPath:  "https://public-assets.getluna.com/images/social/facebook.png",
+			expectedData:  []byte("processed image"),
 		},
 	}
 
@@ -169,8 +182,9 @@ func TestImageManager_ProcessImage(t *testing.T) {
 			defer ctrl.Finish()
 
 			cacheManager := cacheManagerMock.NewMockManager(ctrl)
-			cacheManager.EXPECT().Get(gomock.Any(), tt.format).Return("")
-			cacheManager.EXPECT().Set(gomock.Any(), gomock.Any(), tt.format).Return(tt.expectedPath, nil)
+			cacheManager.EXPECT().Get(gomock.Any(), tt.format).Return(nil)
+			cacheManager.EXPECT().Stale(gomock.Any(), tt.format).Return(nil)
+			cacheManager.EXPECT().Set(gomock.Any(), gomock.Any(), tt.format, gomock.Any(), gomock.Any()).Return(newEntry(tt.expectedData), nil)
 
 			manager, err := NewManager(&Config{
 				AllowedDomains: getAllowedDomains(),
@@ -180,18 +194,552 @@ func TestImageManager_ProcessImage(t *testing.T) {
 				t.FailNow()
 			}
 
-			path, err := manager.ProcessImage(tt.url, tt.width, tt.height, tt.format, tt.quality)
+			entry, err := manager.ProcessImage(context.Background(), tt.url, tt.width, tt.height, tt.format, tt.quality)
 
 			if tt.expectedError {
 				assert.Error(t, err)
 			} else {
-				assert.NoError(t, err)
-				assert.Equal(t, tt.expectedPath, path)
+				if assert.NoError(t, err) {
+					assert.Equal(t, tt.expectedData, readData(t, entry))
+				}
 			}
 		})
 	}
 }
 
+func TestImageManager_ProcessImage_Origins(t *testing.T) {
+	t.Parallel()
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	encoded := new(bytes.Buffer)
+	err := png.Encode(encoded, createTestImage())
+	if err != nil {
+		t.FailNow()
+	}
+
+	origin := sourceMock.NewMockSource(ctrl)
+	origin.EXPECT().Fetch(gomock.Any(), "products/123.png", nil).Return(&sources.Object{
+		Body: io.NopCloser(bytes.NewReader(encoded.Bytes())),
+	}, nil)
+
+	cacheManager := cacheManagerMock.NewMockManager(ctrl)
+	cacheManager.EXPECT().Get(gomock.Any(), "png").Return(nil)
+	cacheManager.EXPECT().Stale(gomock.Any(), "png").Return(nil)
+	cacheManager.EXPECT().Set(gomock.Any(), gomock.Any(), "png", gomock.Any(), gomock.Any()).Return(newEntry([]byte("cached")), nil)
+
+	manager, err := NewManager(&Config{
+		AllowedDomains: getAllowedDomains(),
+		CacheManager:   cacheManager,
+		Origins:        map[string]sources.Source{"bucket": origin},
+	})
+	if err != nil {
+		t.FailNow()
+	}
+
+	assert.True(t, manager.IsURLAllowed("bucket:products/123.png"))
+	assert.False(t, manager.IsURLAllowed(LocalOrigin+":products/123.png"), "local origin is not configured")
+	assert.False(t, manager.IsURLAllowed("unknown:products/123.png"))
+
+	entry, err := manager.ProcessImage(context.Background(), "bucket:products/123.png", 50, 50, "png", 80)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("cached"), readData(t, entry))
+}
+
+func TestImageManager_ProcessImage_Revalidation(t *testing.T) {
+	t.Parallel()
+
+	encoded := new(bytes.Buffer)
+	err := png.Encode(encoded, createTestImage())
+	if err != nil {
+		t.FailNow()
+	}
+
+	var downloads, revalidations int32
+	var etag atomic.Value
+	etag.Store(`"v1"`)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		current := etag.Load().(string)
+		if r.Header.Get("If-None-Match") == current {
+			atomic.AddInt32(&revalidations, 1)
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+
+		atomic.AddInt32(&downloads, 1)
+		w.Header().Set("ETag", current)
+		_, _ = w.Write(encoded.Bytes())
+	}))
+	defer server.Close()
+
+	cache, err := cacheManager.NewManager(&cacheManager.Config{CacheDir: t.TempDir(), MaxAge: 60})
+	if err != nil {
+		t.FailNow()
+	}
+
+	manager, err := NewManager(&Config{
+		AllowedDomains: getAllowedDomains(),
+		CacheManager:   cache,
+	})
+	if err != nil {
+		t.FailNow()
+	}
+
+	imageURL := server.URL + "/image.png"
+	path := cache.GetPath(manager.generateCacheKey(Request{URL: imageURL, Width: 50, Height: 50, Format: "png", Quality: 80}), "png")
+	expire := func(path string) {
+		expired := time.Now().Add(-2 * time.Minute)
+		assert.NoError(t, os.Chtimes(path, expired, expired))
+	}
+
+	processed, err := manager.ProcessImage(context.Background(), imageURL, 50, 50, "png", 80)
+	assert.NoError(t, err)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&downloads))
+
+	// Fresh entries are served without contacting the upstream
+	_, err = manager.ProcessImage(context.Background(), imageURL, 50, 50, "png", 80)
+	assert.NoError(t, err)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&downloads))
+	assert.Equal(t, int32(0), atomic.LoadInt32(&revalidations))
+
+	// Expired entries of unchanged originals are revalidated instead of downloaded
+	expire(path)
+	revalidated, err := manager.ProcessImage(context.Background(), imageURL, 50, 50, "png", 80)
+	assert.NoError(t, err)
+	assert.Equal(t, processed.Hash, revalidated.Hash)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&downloads))
+	assert.Equal(t, int32(1), atomic.LoadInt32(&revalidations))
+
+	// Revalidated entries are fresh again
+	_, err = manager.ProcessImage(context.Background(), imageURL, 50, 50, "png", 80)
+	assert.NoError(t, err)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&revalidations))
+
+	// Changed originals are downloaded again
+	etag.Store(`"v2"`)
+	expire(path)
+	_, err = manager.ProcessImage(context.Background(), imageURL, 50, 50, "png", 80)
+	assert.NoError(t, err)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&downloads))
+	assert.Equal(t, int32(1), atomic.LoadInt32(&revalidations))
+}
+
+func TestImageManager_ProcessImage_SourceCache(t *testing.T) {
+	t.Parallel()
+
+	encoded := new(bytes.Buffer)
+	err := png.Encode(encoded, createTestImage())
+	if err != nil {
+		t.FailNow()
+	}
+
+	var downloads, revalidations int32
+	var etag atomic.Value
+	etag.Store(`"v1"`)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		current := etag.Load().(string)
+		if r.Header.Get("If-None-Match") == current {
+			atomic.AddInt32(&revalidations, 1)
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+
+		atomic.AddInt32(&downloads, 1)
+		w.Header().Set("ETag", current)
+		_, _ = w.Write(encoded.Bytes())
+	}))
+	defer server.Close()
+
+	cache, err := cacheManager.NewManager(&cacheManager.Config{CacheDir: t.TempDir(), MaxAge: 60})
+	if err != nil {
+		t.FailNow()
+	}
+	defer cache.Close()
+
+	sourceCache, err := cacheManager.NewManager(&cacheManager.Config{CacheDir: t.TempDir(), MaxAge: 60})
+	if err != nil {
+		t.FailNow()
+	}
+	defer sourceCache.Close()
+
+	manager, err := NewManager(&Config{
+		AllowedDomains: getAllowedDomains(),
+		CacheManager:   cache,
+		SourceCache:    sourceCache,
+	})
+	if err != nil {
+		t.FailNow()
+	}
+
+	imageURL := server.URL + "/image.png"
+	expire := func(path string) {
+		expired := time.Now().Add(-2 * time.Minute)
+		assert.NoError(t, os.Chtimes(path, expired, expired))
+	}
+	originalPath := sourceCache.GetPath(manager.generateSourceKey(imageURL), sourceFormat)
+	variantPath := cache.GetPath(manager.generateCacheKey(Request{URL: imageURL, Width: 20, Height: 20, Format: "png", Quality: 80}), "png")
+
+	// New variants are derived from the cached original
+	for _, width := range []int{20, 30, 40} {
+		entry, err := manager.ProcessImage(context.Background(), imageURL, width, width, "png", 80)
+		if assert.NoError(t, err) {
+			assert.NotEmpty(t, readData(t, entry))
+		}
+	}
+	assert.Equal(t, int32(1), atomic.LoadInt32(&downloads))
+
+	// Expired originals are revalidated
+	expire(originalPath)
+	_, err = manager.ProcessImage(context.Background(), imageURL, 50, 50, "png", 80)
+	assert.NoError(t, err)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&downloads))
+	assert.Equal(t, int32(1), atomic.LoadInt32(&revalidations))
+
+	// Expired variants of a fresh cached original don't reach the upstream at all
+	expire(variantPath)
+	entry, err := manager.ProcessImage(context.Background(), imageURL, 20, 20, "png", 80)
+	if assert.NoError(t, err) {
+		entry.Close()
+	}
+	assert.Equal(t, int32(1), atomic.LoadInt32(&downloads))
+	assert.Equal(t, int32(1), atomic.LoadInt32(&revalidations))
+
+	// Changed originals are downloaded again
+	etag.Store(`"v2"`)
+	expire(originalPath)
+	expire(variantPath)
+	entry, err = manager.ProcessImage(context.Background(), imageURL, 20, 20, "png", 80)
+	if assert.NoError(t, err) {
+		assert.Equal(t, `"v2"`, entry.Validators.ETag)
+		entry.Close()
+	}
+	assert.Equal(t, int32(2), atomic.LoadInt32(&downloads))
+}
+
+func TestImageManager_Purge(t *testing.T) {
+	t.Parallel()
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	imageURL := "http://imgur.com/image.jpg"
+
+	cacheManager := cacheManagerMock.NewMockManager(ctrl)
+	sourceCache := cacheManagerMock.NewMockManager(ctrl)
+
+	// The cached original is purged too, otherwise new variants would be derived from it
+	gomock.InOrder(
+		sourceCache.EXPECT().Purge(imageURL).Return(1, nil),
+		cacheManager.EXPECT().Purge(imageURL).Return(3, nil),
+	)
+
+	manager, err := NewManager(&Config{
+		AllowedDomains: getAllowedDomains(),
+		CacheManager:   cacheManager,
+		SourceCache:    sourceCache,
+	})
+	if err != nil {
+		t.FailNow()
+	}
+
+	purged, err := manager.Purge(imageURL)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, purged)
+}
+
+// Holds fetches until released, recording how many were in progress at once
+type gatedSource struct {
+	image   []byte
+	release chan struct{}
+	fetches atomic.Int32
+	active  atomic.Int32
+	peak    atomic.Int32
+}
+
+func (s *gatedSource) Fetch(ctx context.Context, key string, conditions *sources.Conditions) (*sources.Object, error) {
+	s.fetches.Add(1)
+	active := s.active.Add(1)
+	defer s.active.Add(-1)
+
+	for peak := s.peak.Load(); active > peak && !s.peak.CompareAndSwap(peak, active); peak = s.peak.Load() {
+	}
+
+	<-s.release
+	return &sources.Object{Body: io.NopCloser(bytes.NewReader(s.image))}, nil
+}
+
+func TestImageManager_ProcessImage_Coalescing(t *testing.T) {
+	t.Parallel()
+
+	encoded := new(bytes.Buffer)
+	err := png.Encode(encoded, createTestImage())
+	if err != nil {
+		t.FailNow()
+	}
+
+	newManager := func(origin sources.Source) *ImageManager {
+		cache, err := cacheManager.NewManager(&cacheManager.Config{CacheDir: t.TempDir(), MaxAge: 60})
+		if err != nil {
+			t.FailNow()
+		}
+		t.Cleanup(func() { cache.Close() })
+
+		manager, err := NewManager(&Config{
+			AllowedDomains: getAllowedDomains(),
+			CacheManager:   cache,
+			Origins:        map[string]sources.Source{"bucket": origin},
+		})
+		if err != nil {
+			t.FailNow()
+		}
+		return manager
+	}
+
+	t.Run("same variant is processed once", func(t *testing.T) {
+		origin := &gatedSource{image: encoded.Bytes(), release: make(chan struct{})}
+		manager := newManager(origin)
+
+		const callers = 10
+		results := make([][]byte, callers)
+		errs := make([]error, callers)
+
+		var wg sync.WaitGroup
+		for i := 0; i < callers; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				entry, err := manager.ProcessImage(context.Background(), "bucket:products/123.png", 50, 50, "png", 80)
+				errs[i] = err
+				if err == nil {
+					results[i] = readData(t, entry)
+				}
+			}(i)
+		}
+
+		// Every other caller joins the flight of the first one
+		key := manager.generateCacheKey(Request{URL: "bucket:products/123.png", Width: 50, Height: 50, Format: "png", Quality: 80})
+		assert.Eventually(t, func() bool {
+			manager.flights.mu.Lock()
+			defer manager.flights.mu.Unlock()
+			f, ok := manager.flights.flights[key]
+			return ok && f.waiting == callers
+		}, time.Second, time.Millisecond)
+
+		close(origin.release)
+		wg.Wait()
+
+		assert.Equal(t, int32(1), origin.fetches.Load())
+		for i := 0; i < callers; i++ {
+			if assert.NoError(t, errs[i]) {
+				assert.NotEmpty(t, results[i])
+				assert.Equal(t, results[0], results[i])
+			}
+		}
+	})
+
+	t.Run("different variants are processed in parallel", func(t *testing.T) {
+		origin := &gatedSource{image: encoded.Bytes(), release: make(chan struct{})}
+		manager := newManager(origin)
+
+		widths := []int{20, 30, 40}
+
+		var wg sync.WaitGroup
+		for _, width := range widths {
+			wg.Add(1)
+			go func(width int) {
+				defer wg.Done()
+				entry, err := manager.ProcessImage(context.Background(), "bucket:products/123.png", width, width, "png", 80)
+				if assert.NoError(t, err) {
+					entry.Close()
+				}
+			}(width)
+		}
+
+		// All fetches are in progress at once, a global lock would only ever allow one
+		assert.Eventually(t, func() bool {
+			return origin.active.Load() == int32(len(widths))
+		}, time.Second, time.Millisecond)
+
+		close(origin.release)
+		wg.Wait()
+
+		assert.Equal(t, int32(len(widths)), origin.fetches.Load())
+		assert.Equal(t, int32(len(widths)), origin.peak.Load())
+	})
+}
+
+func TestImageManager_ProcessImage_Canceled(t *testing.T) {
+	t.Parallel()
+
+	// The upstream sends part of the image and stalls until the client disconnects
+	disconnected := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/png")
+		w.Write([]byte("partial"))
+		w.(http.Flusher).Flush()
+
+		<-r.Context().Done()
+		close(disconnected)
+	}))
+	defer server.Close()
+
+	upstream, err := sources.NewHTTPSource(&sources.HTTPConfig{BaseURL: server.URL})
+	if err != nil {
+		t.FailNow()
+	}
+
+	cache, err := cacheManager.NewManager(&cacheManager.Config{CacheDir: t.TempDir(), MaxAge: 60})
+	if err != nil {
+		t.FailNow()
+	}
+	defer cache.Close()
+
+	manager, err := NewManager(&Config{
+		AllowedDomains: getAllowedDomains(),
+		CacheManager:   cache,
+		Origins:        map[string]sources.Source{"slow": upstream},
+	})
+	if err != nil {
+		t.FailNow()
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	result := make(chan error)
+	go func() {
+		_, err := manager.ProcessImage(ctx, "slow:products/123.png", 50, 50, "png", 80)
+		result <- err
+	}()
+
+	// Cancel once the fetch is stalled halfway through
+	assert.Eventually(t, func() bool {
+		manager.flights.mu.Lock()
+		defer manager.flights.mu.Unlock()
+		return len(manager.flights.flights) == 1
+	}, time.Second, time.Millisecond)
+	time.Sleep(50 * time.Millisecond)
+	cancel()
+
+	select {
+	case err := <-result:
+		assert.ErrorIs(t, err, context.Canceled)
+	case <-time.After(time.Second):
+		t.Fatal("ProcessImage didn't return once canceled")
+	}
+
+	// The upstream request is abandoned as well instead of being read to the end
+	select {
+	case <-disconnected:
+	case <-time.After(time.Second):
+		t.Fatal("upstream request wasn't canceled")
+	}
+}
+
+// Records the reservations of decodes
+type recordingBudget struct {
+	reserved []int64
+	released int
+}
+
+func (b *recordingBudget) Reserve(ctx context.Context, size int64) (func(), error) {
+	b.reserved = append(b.reserved, size)
+	return func() { b.released++ }, nil
+}
+
+func TestImageManager_ProcessImage_Admission(t *testing.T) {
+	t.Parallel()
+
+	encoded := new(bytes.Buffer)
+	err := png.Encode(encoded, createTestImage())
+	if err != nil {
+		t.FailNow()
+	}
+
+	// A 1000x1 banner, the extreme aspect ratio makes its variants much larger than itself
+	banner := new(bytes.Buffer)
+	err = png.Encode(banner, image.NewNRGBA(image.Rect(0, 0, 1000, 1)))
+	if err != nil {
+		t.FailNow()
+	}
+	originals := map[string][]byte{"products/123.png": encoded.Bytes(), "products/banner.png": banner.Bytes()}
+
+	newManager := func(ctrl *gomock.Controller, cache cacheManager.Manager, maxPixels int64, budget MemoryBudget, path string) *ImageManager {
+		origin := sourceMock.NewMockSource(ctrl)
+		origin.EXPECT().Fetch(gomock.Any(), path, nil).Return(&sources.Object{
+			Body: io.NopCloser(bytes.NewReader(originals[path])),
+		}, nil)
+
+		manager, err := NewManager(&Config{
+			AllowedDomains: getAllowedDomains(),
+			CacheManager:   cache,
+			Origins:        map[string]sources.Source{"bucket": origin},
+			MaxPixels:      maxPixels,
+			MemoryBudget:   budget,
+		})
+		if err != nil {
+			t.FailNow()
+		}
+		return manager
+	}
+
+	t.Run("originals over the pixel limit aren't decoded", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		cache := cacheManagerMock.NewMockManager(ctrl)
+		cache.EXPECT().Get(gomock.Any(), "png").Return(nil)
+		cache.EXPECT().Stale(gomock.Any(), "png").Return(nil)
+
+		budget := &recordingBudget{}
+		manager := newManager(ctrl, cache, 100*100-1, budget, "products/123.png")
+
+		_, err := manager.ProcessImage(context.Background(), "bucket:products/123.png", 50, 50, "png", 80)
+		assert.ErrorIs(t, err, ErrTooManyPixels)
+		assert.Empty(t, budget.reserved, "memory is only reserved for admitted originals")
+	})
+
+	t.Run("variants over the pixel limit aren't resized", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		cache := cacheManagerMock.NewMockManager(ctrl)
+		cache.EXPECT().Get(gomock.Any(), "png").Return(nil)
+		cache.EXPECT().Stale(gomock.Any(), "png").Return(nil)
+
+		budget := &recordingBudget{}
+		manager := newManager(ctrl, cache, 100*100, budget, "products/banner.png")
+
+		// The 1000x1 banner is under the limit, its variant 100 pixels high keeping the aspect ratio isn't
+		_, err := manager.ProcessImage(context.Background(), "bucket:products/banner.png", 0, 100, "png", 80)
+		assert.ErrorIs(t, err, ErrTooManyPixels)
+		assert.Empty(t, budget.reserved, "memory is only reserved for admitted variants")
+	})
+
+	t.Run("decodes reserve their estimated memory", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		cache := cacheManagerMock.NewMockManager(ctrl)
+		cache.EXPECT().Get(gomock.Any(), "png").Return(nil)
+		cache.EXPECT().Stale(gomock.Any(), "png").Return(nil)
+		cache.EXPECT().Set(gomock.Any(), gomock.Any(), "png", gomock.Any(), gomock.Any()).Return(newEntry([]byte("cached")), nil)
+
+		budget := &recordingBudget{}
+		manager := newManager(ctrl, cache, 100*100, budget, "products/123.png")
+
+		entry, err := manager.ProcessImage(context.Background(), "bucket:products/123.png", 50, 50, "png", 80)
+		if assert.NoError(t, err) {
+			entry.Close()
+		}
+
+		// The 100x100 original and its 50x50 variant, at 4 bytes per pixel
+		assert.Equal(t, []int64{100*100*4 + 50*50*4}, budget.reserved)
+		assert.Equal(t, 1, budget.released)
+	})
+}
+
 func TestImageManager_generateCacheKey(t *testing.T) {
 	ctrl := gomock.NewController(t)
 	defer ctrl.Finish()
@@ -206,12 +754,61 @@ func TestImageManager_generateCacheKey(t *testing.T) {
 		t.FailNow()
 	}
 
-	key := manager.generateCacheKey("http://example.com/image.jpg", 100, 100, "jpeg")
-	assert.NotEmpty(t, key)
+	req := Request{URL: "http://example.com/image.jpg", Width: 100, Height: 100, Format: "jpeg", Quality: 85}
+
+	key := manager.generateCacheKey(req)
+	assert.Len(t, key, 64)
 
 	// Test consistency
-	key2 := manager.generateCacheKey("http://example.com/image.jpg", 100, 100, "jpeg")
+	key2 := manager.generateCacheKey(req)
 	assert.Equal(t, key, key2)
+
+	tests := []struct {
+		name  string
+		req   Request
+		equal bool
+	}{
+		{name: "Different quality", req: Request{URL: req.URL, Width: 100, Height: 100, Format: "jpeg", Quality: 30}},
+		{name: "Different format", req: Request{URL: req.URL, Width: 100, Height: 100, Format: "webp", Quality: 85}},
+		{name: "Swapped dimensions", req: Request{URL: req.URL, Width: 100, Height: 0, Format: "jpeg", Quality: 85}},
+		{name: "Ambiguous separators", req: Request{URL: req.URL + "_100", Width: 100, Format: "jpeg", Quality: 85}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.NotEqual(t, key, manager.generateCacheKey(tt.req))
+		})
+	}
+
+	// Quality doesn't affect lossless PNG
+	png := Request{URL: req.URL, Width: 100, Height: 100, Format: "png", Quality: 30}
+	assert.Equal(t, manager.generateCacheKey(png), manager.generateCacheKey(Request{URL: req.URL, Width: 100, Height: 100, Format: "png", Quality: 90}))
+
+	// The salt invalidates every key
+	salted, err := NewManager(&Config{
+		AllowedDomains: getAllowedDomains(),
+		CacheManager:   cacheManager,
+		KeySalt:        "2024-06",
+	})
+	if err != nil {
+		t.FailNow()
+	}
+	assert.NotEqual(t, key, salted.generateCacheKey(req))
+}
+
+// Helper function to create a cache entry
+func newEntry(data []byte) *cacheManager.Entry {
+	return cacheManager.NewEntry(data, "jpeg", time.Now(), "", nil)
+}
+
+// Helper function to read and close a cache entry
+func readData(t *testing.T, entry *cacheManager.Entry) []byte {
+	t.Helper()
+	defer entry.Close()
+
+	data, err := entry.ReadAll()
+	assert.NoError(t, err)
+	return data
 }
 
 // Helper function to create test image
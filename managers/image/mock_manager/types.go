@@ -5,6 +5,8 @@
 package mock_managers
 
 import (
+	managers "antman-proxy/managers/cache"
+	context "context"
 	reflect "reflect"
 
 	gomock "github.com/golang/mock/gomock"
@@ -48,16 +50,31 @@ func (mr *MockManagerMockRecorder) IsURLAllowed(imageURL interface{}) *gomock.Ca
 }
 
 // ProcessImage mocks base method.
-func (m *MockManager) ProcessImage(imageURL string, width, height int, format string, quality int) (string, error) {
+func (m *MockManager) ProcessImage(ctx context.Context, imageURL string, width, height int, format string, quality int) (*managers.Entry, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "ProcessImage", imageURL, width, height, format, quality)
-	ret0, _ := ret[0].(string)
+	ret := m.ctrl.Call(m, "ProcessImage", ctx, imageURL, width, height, format, quality)
+	ret0, _ := ret[0].(*managers.Entry)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
 // ProcessImage indicates an expected call of ProcessImage.
-func (mr *MockManagerMockRecorder) ProcessImage(imageURL, width, height, format, quality interface{}) *gomock.Call {
+func (mr *MockManagerMockRecorder) ProcessImage(ctx, imageURL, width, height, format, quality interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ProcessImage", reflect.TypeOf((*MockManager)(nil).ProcessImage), imageURL, width, height, format, quality)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ProcessImage", reflect.TypeOf((*MockManager)(nil).ProcessImage), ctx, imageURL, width, height, format, quality)
+}
+
+// Purge mocks base method.
+func (m *MockManager) Purge(imageURL string) (int, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Purge", imageURL)
+	ret0, _ := ret[0].(int)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Purge indicates an expected call of Purge.
+func (mr *MockManagerMockRecorder) Purge(imageURL interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Purge", reflect.TypeOf((*MockManager)(nil).Purge), imageURL)
 }
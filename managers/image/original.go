@@ -0,0 +1,120 @@
+package managers
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+
+	cacheManager "antman-proxy/managers/cache"
+	"antman-proxy/sources"
+)
+
+// Format cached originals are stored under, they keep whatever encoding the upstream used.
+const sourceFormat = "orig"
+
+// original is the upstream image variants are derived from.
+type original struct {
+	body       io.ReadCloser
+	validators *cacheManager.Validators
+}
+
+// Reads the body of a cache entry, closing the entry along with it.
+type entryReader struct {
+	*cacheManager.Entry
+}
+
+func (r entryReader) Read(p []byte) (int, error) {
+	return r.Body.Read(p)
+}
+
+func conditionsFor(validators *cacheManager.Validators) *sources.Conditions {
+	if validators == nil {
+		return nil
+	}
+
+	return &sources.Conditions{
+		IfNoneMatch:     validators.ETag,
+		IfModifiedSince: validators.LastModified,
+	}
+}
+
+// Fetches the original of an image, from the source cache when one is configured. validators are those of an
+// expired variant, sources.ErrNotModified is returned when the original is still the version it was derived from.
+func (m *ImageManager) fetchOriginal(ctx context.Context, imageURL string, validators *cacheManager.Validators) (*original, error) {
+	source, key, _ := m.resolveSource(imageURL)
+
+	if m.sourceCache == nil {
+		object, err := source.Fetch(ctx, key, conditionsFor(validators))
+		if err != nil {
+			return nil, err
+		}
+
+		return &original{
+			body:       object.Body,
+			validators: &cacheManager.Validators{ETag: object.ETag, LastModified: object.LastModified},
+		}, nil
+	}
+
+	sourceKey := m.generateSourceKey(imageURL)
+
+	cached := m.sourceCache.Get(sourceKey, sourceFormat)
+	if cached == nil {
+		// Expired originals are revalidated so unchanged images aren't downloaded again
+		conditions := conditionsFor(validators)
+		stale := m.sourceCache.Stale(sourceKey, sourceFormat)
+		if stale != nil {
+			conditions = conditionsFor(stale.Validators)
+		}
+
+		object, err := source.Fetch(ctx, key, conditions)
+		switch {
+		case errors.Is(err, sources.ErrNotModified) && stale != nil:
+			err = m.sourceCache.Refresh(sourceKey, sourceFormat)
+			if err != nil {
+				stale.Close()
+				return nil, err
+			}
+			cached = stale
+		case err != nil:
+			if stale != nil {
+				stale.Close()
+			}
+			return nil, err
+		default:
+			if stale != nil {
+				stale.Close()
+			}
+
+			data, err := io.ReadAll(object.Body)
+			object.Body.Close()
+			if err != nil {
+				return nil, err
+			}
+
+			cached, err = m.sourceCache.Set(sourceKey, data, sourceFormat, imageURL, &cacheManager.Validators{
+				ETag:         object.ETag,
+				LastModified: object.LastModified,
+			})
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if validators != nil && validators.Equal(cached.Validators) {
+		cached.Close()
+		return nil, sources.ErrNotModified
+	}
+
+	return &original{
+		body:       entryReader{cached},
+		validators: cached.Validators,
+	}, nil
+}
+
+func (m *ImageManager) generateSourceKey(url string) string {
+	sum := sha256.Sum256([]byte(url))
+	return hex.EncodeToString(sum[:])
+}
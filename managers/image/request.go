@@ -0,0 +1,74 @@
+package managers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+
+	"github.com/nfnt/resize"
+)
+
+// PipelineVersion is part of every cache key. Bump it whenever decoding, resizing or encoding changes the output, so
+// variants produced by the previous pipeline are no longer served.
+const PipelineVersion = 1
+
+// Processing options that aren't exposed as parameters, they're part of the key so changing them is enough to
+// invalidate the variants produced with the previous values.
+const (
+	interpolation = resize.Lanczos3
+	webpLossless  = false
+)
+
+// Request describes a variant of an image. Every option affecting the processed output must be part of it.
+type Request struct {
+	URL     string
+	Width   int
+	Height  int
+	Format  string
+	Quality int
+}
+
+// Canonical form of a request hashed into its cache key. Fields are serialized in declaration order, so the
+// serialization is deterministic.
+type canonicalRequest struct {
+	Version       int    `json:"version"`
+	Salt          string `json:"salt,omitempty"`
+	URL           string `json:"url"`
+	Width         int    `json:"width"`
+	Height        int    `json:"height"`
+	Format        string `json:"format"`
+	Quality       int    `json:"quality"`
+	Interpolation int    `json:"interpolation"`
+	Lossless      bool   `json:"lossless"`
+}
+
+func (r Request) canonical(salt string) canonicalRequest {
+	canonical := canonicalRequest{
+		Version:       PipelineVersion,
+		Salt:          salt,
+		URL:           r.URL,
+		Width:         r.Width,
+		Height:        r.Height,
+		Format:        r.Format,
+		Quality:       r.Quality,
+		Interpolation: int(interpolation),
+	}
+
+	switch r.Format {
+	case "png":
+		// PNG is lossless, requests differing only in quality produce the same image
+		canonical.Quality = 0
+	case "webp":
+		canonical.Lossless = webpLossless
+	}
+
+	return canonical
+}
+
+// Key returns the SHA-256 of the canonical form of the request, salted with salt.
+func (r Request) Key(salt string) string {
+	// Marshaling a struct of strings, integers and booleans can't fail
+	data, _ := json.Marshal(r.canonical(salt))
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
@@ -1,6 +1,13 @@
 package managers
 
+import (
+	"context"
+
+	cacheManager "antman-proxy/managers/cache"
+)
+
 type Manager interface {
 	IsURLAllowed(imageURL string) bool
-	ProcessImage(imageURL string, width, height int, format string, quality int) (string, error)
+	ProcessImage(ctx context.Context, imageURL string, width, height int, format string, quality int) (*cacheManager.Entry, error)
+	Purge(imageURL string) (int, error)
 }
@@ -0,0 +1,96 @@
+package managers
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// Variant is a processed version of an image.
+type Variant struct {
+	Width   int    `json:"width"`
+	Height  int    `json:"height"`
+	Format  string `json:"format"`
+	Quality int    `json:"quality"`
+}
+
+type WarmFailure struct {
+	URL     string `json:"url"`
+	Variant string `json:"variant"`
+	Error   string `json:"error"`
+}
+
+// WarmReport summarizes a warm run, failures are sorted by URL and variant.
+type WarmReport struct {
+	Succeeded int           `json:"succeeded"`
+	Failed    int           `json:"failed"`
+	Failures  []WarmFailure `json:"failures,omitempty"`
+}
+
+type warmJob struct {
+	url     string
+	name    string
+	variant Variant
+}
+
+// Warm processes every named variant of every image so they're cached before they're requested, with at most
+// concurrency variants being processed at once. Variants not processed yet once ctx is canceled are reported as failed.
+func Warm(ctx context.Context, manager Manager, urls []string, variants map[string]Variant, concurrency int) *WarmReport {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	jobs := make(chan warmJob)
+	report := &WarmReport{}
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for job := range jobs {
+				err := warm(ctx, manager, job)
+
+				mu.Lock()
+				if err != nil {
+					report.Failed++
+					report.Failures = append(report.Failures, WarmFailure{URL: job.url, Variant: job.name, Error: err.Error()})
+				} else {
+					report.Succeeded++
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+
+	for _, url := range urls {
+		for name, variant := range variants {
+			jobs <- warmJob{url: url, name: name, variant: variant}
+		}
+	}
+	close(jobs)
+	wg.Wait()
+
+	sort.Slice(report.Failures, func(a, b int) bool {
+		if report.Failures[a].URL != report.Failures[b].URL {
+			return report.Failures[a].URL < report.Failures[b].URL
+		}
+		return report.Failures[a].Variant < report.Failures[b].Variant
+	})
+
+	return report
+}
+
+func warm(ctx context.Context, manager Manager, job warmJob) error {
+	if !manager.IsURLAllowed(job.url) {
+		return fmt.Errorf("Invalid URL domain")
+	}
+
+	entry, err := manager.ProcessImage(ctx, job.url, job.variant.Width, job.variant.Height, job.variant.Format, job.variant.Quality)
+	if err != nil {
+		return err
+	}
+	return entry.Close()
+}
@@ -0,0 +1,83 @@
+package managers
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	cacheManager "antman-proxy/managers/cache"
+)
+
+// Records the processed variants, failing for images on the failing domain
+type warmManager struct {
+	mu        sync.Mutex
+	processed []string
+	active    atomic.Int32
+	peak      atomic.Int32
+}
+
+func (m *warmManager) IsURLAllowed(imageURL string) bool {
+	return imageURL != "http://blocked.com/image.jpg"
+}
+
+func (m *warmManager) ProcessImage(ctx context.Context, imageURL string, width, height int, format string, quality int) (*cacheManager.Entry, error) {
+	active := m.active.Add(1)
+	defer m.active.Add(-1)
+	for peak := m.peak.Load(); active > peak && !m.peak.CompareAndSwap(peak, active); peak = m.peak.Load() {
+	}
+	time.Sleep(5 * time.Millisecond)
+
+	if imageURL == "http://failing.com/image.jpg" {
+		return nil, fmt.Errorf("processing failed")
+	}
+
+	m.mu.Lock()
+	m.processed = append(m.processed, fmt.Sprintf("%s %dx%d %s %d", imageURL, width, height, format, quality))
+	m.mu.Unlock()
+	return newEntry([]byte("processed image")), nil
+}
+
+func (m *warmManager) Purge(imageURL string) (int, error) {
+	return 0, nil
+}
+
+func TestWarm(t *testing.T) {
+	t.Parallel()
+
+	manager := &warmManager{}
+	urls := []string{
+		"http://imgur.com/a.jpg",
+		"http://imgur.com/b.jpg",
+		"http://failing.com/image.jpg",
+		"http://blocked.com/image.jpg",
+	}
+	variants := map[string]Variant{
+		"thumb": {Width: 320, Height: 320, Format: "webp", Quality: 70},
+		"hero":  {Width: 1600, Format: "jpeg", Quality: 85},
+	}
+
+	report := Warm(context.Background(), manager, urls, variants, 2)
+
+	assert.Equal(t, 4, report.Succeeded)
+	assert.Equal(t, 4, report.Failed)
+	assert.Equal(t, []WarmFailure{
+		{URL: "http://blocked.com/image.jpg", Variant: "hero", Error: "Invalid URL domain"},
+		{URL: "http://blocked.com/image.jpg", Variant: "thumb", Error: "Invalid URL domain"},
+		{URL: "http://failing.com/image.jpg", Variant: "hero", Error: "processing failed"},
+		{URL: "http://failing.com/image.jpg", Variant: "thumb", Error: "processing failed"},
+	}, report.Failures)
+
+	assert.ElementsMatch(t, []string{
+		"http://imgur.com/a.jpg 320x320 webp 70",
+		"http://imgur.com/a.jpg 1600x0 jpeg 85",
+		"http://imgur.com/b.jpg 320x320 webp 70",
+		"http://imgur.com/b.jpg 1600x0 jpeg 85",
+	}, manager.processed)
+
+	assert.LessOrEqual(t, manager.peak.Load(), int32(2), "concurrency should be bounded")
+}
@@ -1,6 +1,7 @@
 package middlewares
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"net/http"
@@ -23,6 +24,28 @@ type RateLimiterConfig struct {
 	Capacity   float64                   // Maximum tokens
 	RefillRate float64                   // Tokens per second
 	Client     func(*gin.Context) string // Function to identify clients
+	Store      LimiterStore              // Keeps the buckets of the clients, defaults to a MemoryStore
+}
+
+// LimiterStore keeps a token bucket per client.
+type LimiterStore interface {
+	// Take takes a token from the bucket of the client, refilled at refillRate tokens per second up to capacity, and
+	// returns whether one was left.
+	Take(ctx context.Context, client string, capacity, refillRate float64) (bool, error)
+}
+
+// MemoryStore keeps the buckets in memory, so each replica limits the clients on its own.
+type MemoryStore struct {
+	limiters sync.Map
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{}
+}
+
+func (s *MemoryStore) Take(ctx context.Context, client string, capacity, refillRate float64) (bool, error) {
+	limiterI, _ := s.limiters.LoadOrStore(client, NewRateLimiter(&RateLimiterConfig{Capacity: capacity, RefillRate: refillRate}))
+	return limiterI.(*Limiter).allow(), nil
 }
 
 func NewRateLimiter(cfg *RateLimiterConfig) *Limiter {
@@ -63,7 +86,9 @@ func RateLimiter(cfg *RateLimiterConfig) gin.HandlerFunc {
 		log.Fatal("RateLimiter Config is nil!")
 	}
 
-	limiters := &sync.Map{}
+	if cfg.Store == nil {
+		cfg.Store = NewMemoryStore()
+	}
 
 	if cfg.Client == nil {
 		cfg.Client = func(c *gin.Context) string {
@@ -74,10 +99,14 @@ func RateLimiter(cfg *RateLimiterConfig) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		clientID := cfg.Client(c)
 
-		limiterI, _ := limiters.LoadOrStore(clientID, NewRateLimiter(cfg))
-		limiter := limiterI.(*Limiter)
+		allowed, err := cfg.Store.Take(c.Request.Context(), clientID, cfg.Capacity, cfg.RefillRate)
+		if err != nil {
+			// Requests aren't turned away because the store is unavailable
+			log.Println("Rate limiter store error: ", err)
+			allowed = true
+		}
 
-		if !limiter.allow() {
+		if !allowed {
 			c.Header("X-RateLimit-Limit", fmt.Sprintf("%.0f", cfg.Capacity))
 
 			retryAfter := time.Duration(1000/cfg.RefillRate) * time.Millisecond
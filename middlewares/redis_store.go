@@ -0,0 +1,83 @@
+package middlewares
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const DefaultRedisStorePrefix = "antman:ratelimit:"
+
+// Refills and takes a token from the bucket atomically, timed by the Redis clock so replicas with skewed clocks
+// agree. Buckets expire once they would be full again, as they're then no different from a new one.
+var takeScript = redis.NewScript(`
+local capacity = tonumber(ARGV[1])
+local refill_rate = tonumber(ARGV[2])
+
+local time = redis.call('TIME')
+local now = tonumber(time[1]) + tonumber(time[2]) / 1000000
+
+local bucket = redis.call('HMGET', KEYS[1], 'tokens', 'timestamp')
+local tokens = tonumber(bucket[1])
+local timestamp = tonumber(bucket[2])
+if tokens == nil or timestamp == nil then
+	tokens = capacity
+	timestamp = now
+end
+
+tokens = math.min(capacity, tokens + math.max(0, now - timestamp) * refill_rate)
+
+local allowed = 0
+if tokens >= 1 then
+	tokens = tokens - 1
+	allowed = 1
+end
+
+redis.call('HSET', KEYS[1], 'tokens', tostring(tokens), 'timestamp', tostring(now))
+redis.call('EXPIRE', KEYS[1], math.ceil((capacity - tokens) / refill_rate) + 1)
+
+return allowed
+`)
+
+type RedisStoreConfig struct {
+	Client redis.UniversalClient
+	Prefix string // Prepended to the key of every bucket, defaults to DefaultRedisStorePrefix
+}
+
+// RedisStore keeps the buckets in Redis, so clients are limited across every replica.
+type RedisStore struct {
+	client redis.UniversalClient
+	prefix string
+}
+
+func NewRedisStore(cfg *RedisStoreConfig) (*RedisStore, error) {
+	if cfg == nil {
+		return nil, fmt.Errorf("RedisStore config is nil!")
+	}
+
+	if cfg.Client == nil {
+		return nil, fmt.Errorf("cfg.Client is nil!")
+	}
+
+	if cfg.Prefix == "" {
+		cfg.Prefix = DefaultRedisStorePrefix
+	}
+
+	return &RedisStore{
+		client: cfg.Client,
+		prefix: cfg.Prefix,
+	}, nil
+}
+
+func (s *RedisStore) Take(ctx context.Context, client string, capacity, refillRate float64) (bool, error) {
+	allowed, err := takeScript.Run(ctx, s.client, []string{s.prefix + client}, capacity, refillRate).Int()
+	if err != nil {
+		return false, err
+	}
+	return allowed == 1, nil
+}
+
+func (s *RedisStore) Close() error {
+	return s.client.Close()
+}
@@ -0,0 +1,96 @@
+package middlewares
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewRedisStore(t *testing.T) {
+	t.Parallel()
+
+	_, err := NewRedisStore(nil)
+	assert.Equal(t, "RedisStore config is nil!", err.Error())
+
+	_, err = NewRedisStore(&RedisStoreConfig{})
+	assert.Equal(t, "cfg.Client is nil!", err.Error())
+}
+
+func TestRedisStore(t *testing.T) {
+	t.Parallel()
+
+	server := miniredis.RunT(t)
+	now := time.Now()
+	server.SetTime(now)
+
+	store, err := NewRedisStore(&RedisStoreConfig{Client: redis.NewClient(&redis.Options{Addr: server.Addr()})})
+	require.NoError(t, err)
+	defer store.Close()
+
+	take := func(client string) bool {
+		allowed, err := store.Take(context.Background(), client, 2, 0.5)
+		require.NoError(t, err)
+		return allowed
+	}
+
+	t.Run("Bucket is drained and refilled", func(t *testing.T) {
+		assert.True(t, take("drained"))
+		assert.True(t, take("drained"))
+		assert.False(t, take("drained"))
+
+		// A token is back after 2 seconds at 0.5 tokens per second
+		server.SetTime(now.Add(time.Second))
+		assert.False(t, take("drained"))
+		server.SetTime(now.Add(2 * time.Second))
+		assert.True(t, take("drained"))
+		assert.False(t, take("drained"))
+	})
+
+	t.Run("Clients have their own bucket", func(t *testing.T) {
+		assert.True(t, take("first"))
+		assert.True(t, take("first"))
+		assert.False(t, take("first"))
+		assert.True(t, take("second"))
+	})
+
+	t.Run("Buckets expire once full again", func(t *testing.T) {
+		assert.True(t, take("expiring"))
+		assert.True(t, server.Exists(DefaultRedisStorePrefix+"expiring"))
+		assert.Equal(t, 3*time.Second, server.TTL(DefaultRedisStorePrefix+"expiring"))
+	})
+}
+
+func TestRateLimiterRedisStore(t *testing.T) {
+	t.Parallel()
+
+	server := miniredis.RunT(t)
+	store, err := NewRedisStore(&RedisStoreConfig{Client: redis.NewClient(&redis.Options{Addr: server.Addr()})})
+	require.NoError(t, err)
+	defer store.Close()
+
+	// Replicas sharing the store share the limit of a client
+	config := &RateLimiterConfig{Capacity: 3, RefillRate: 0.001, Store: store}
+	replicas := []*gin.Engine{setupRouter(config), setupRouter(config)}
+
+	var codes []int
+	for i := 0; i < 4; i++ {
+		w := httptest.NewRecorder()
+		replicas[i%2].ServeHTTP(w, httptest.NewRequest("GET", "/test", nil))
+		codes = append(codes, w.Code)
+	}
+	assert.Equal(t, []int{200, 200, 200, 429}, codes)
+
+	// Requests are let through while the store is unavailable
+	server.Close()
+	w := httptest.NewRecorder()
+	replicas[0].ServeHTTP(w, httptest.NewRequest("GET", "/test", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+}
@@ -9,6 +9,7 @@ import (
 
 	"github.com/gin-gonic/gin"
 
+	adminHandler "antman-proxy/handlers/admin"
 	htmlHandler "antman-proxy/handlers/html"
 	imageHandler "antman-proxy/handlers/image"
 	cacheManager "antman-proxy/managers/cache"
@@ -17,11 +18,14 @@ import (
 )
 
 type Config struct {
-	HtmlHandler  htmlHandler.Handler
-	ImageHandler imageHandler.Handler
-	CacheManager cacheManager.Manager
-	ImageManager imageManager.Manager
-	Port         string
+	HtmlHandler    htmlHandler.Handler
+	ImageHandler   imageHandler.Handler
+	CacheManager   cacheManager.Manager
+	ImageManager   imageManager.Manager
+	AdminHandler   adminHandler.Handler     // Optional, the admin endpoints are only served when set
+	AdminToken     string                   // Bearer token required by the admin endpoints
+	RateLimitStore middlewares.LimiterStore // Optional, defaults to limiting clients in memory per replica
+	Port           string
 }
 
 type Server struct {
@@ -53,6 +57,10 @@ func NewServer(cfg *Config) *http.Server {
 		log.Fatal("cfg.ImageManager is nil!")
 	}
 
+	if cfg.AdminHandler != nil && cfg.AdminToken == "" {
+		log.Fatal("cfg.AdminHandler requires cfg.AdminToken!")
+	}
+
 	// Gin router config with custom HTTP configuration and graceful shutdown of the server built-in
 	// Creates a router without any middleware by default
 	router := gin.Default()
@@ -77,13 +85,25 @@ func NewServer(cfg *Config) *http.Server {
 		refillRate = float64(1)
 	}
 
-	router.Use(middlewares.RateLimiter(&middlewares.RateLimiterConfig{Capacity: capacity, RefillRate: refillRate}))
+	router.Use(middlewares.RateLimiter(&middlewares.RateLimiterConfig{
+		Capacity:   capacity,
+		RefillRate: refillRate,
+		Store:      cfg.RateLimitStore,
+	}))
 
 	router.LoadHTMLGlob("static/templates/*")
 
 	router.GET("/", cfg.HtmlHandler.HandleIndex)
 	router.GET("/resize", cfg.ImageHandler.HandleResize)
 
+	if cfg.AdminHandler != nil {
+		admin := router.Group("/admin", middlewares.AdminAuth(cfg.AdminToken))
+		admin.DELETE("/cache", cfg.AdminHandler.HandlePurge)
+		admin.POST("/warm", cfg.AdminHandler.HandleWarm)
+		admin.GET("/stats", cfg.AdminHandler.HandleStats)
+		admin.GET("/metrics", cfg.AdminHandler.HandleMetrics)
+	}
+
 	s := &http.Server{
 		Addr:           ":" + cfg.Port,
 		Handler:        router,
@@ -0,0 +1,151 @@
+package sources
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// SymlinkPolicy controls whether symbolic links below the root are followed.
+type SymlinkPolicy string
+
+const (
+	SymlinksDeny       SymlinkPolicy = "deny"        // Reject any path containing a symlink
+	SymlinksWithinRoot SymlinkPolicy = "within_root" // Follow symlinks as long as the target stays inside the root
+	SymlinksFollow     SymlinkPolicy = "follow"      // Follow symlinks anywhere
+)
+
+var (
+	ErrInvalidPath = errors.New("invalid path")
+	ErrSymlink     = errors.New("path contains a symlink")
+)
+
+type FilesystemConfig struct {
+	Root     string
+	Symlinks SymlinkPolicy // Defaults to SymlinksDeny
+}
+
+// FilesystemSource serves originals from a directory on the local filesystem.
+type FilesystemSource struct {
+	root     string
+	symlinks SymlinkPolicy
+}
+
+func NewFilesystemSource(cfg *FilesystemConfig) (*FilesystemSource, error) {
+	if cfg == nil {
+		return nil, fmt.Errorf("FilesystemSource Config is nil!")
+	}
+
+	if cfg.Root == "" {
+		return nil, fmt.Errorf("cfg.Root is blank!")
+	}
+
+	switch cfg.Symlinks {
+	case "":
+		cfg.Symlinks = SymlinksDeny
+	case SymlinksDeny, SymlinksWithinRoot, SymlinksFollow:
+	default:
+		return nil, fmt.Errorf("unsupported symlink policy: %s", cfg.Symlinks)
+	}
+
+	root, err := filepath.Abs(cfg.Root)
+	if err != nil {
+		return nil, err
+	}
+
+	// The root itself may live behind a symlink, only links below it are subject to the policy
+	root, err = filepath.EvalSymlinks(root)
+	if err != nil {
+		return nil, err
+	}
+
+	return &FilesystemSource{
+		root:     root,
+		symlinks: cfg.Symlinks,
+	}, nil
+}
+
+func (s *FilesystemSource) isWithinRoot(name string) bool {
+	rel, err := filepath.Rel(s.root, name)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// Resolves a slash separated key to a file below the root, rejecting traversal attempts and applying the symlink policy.
+func (s *FilesystemSource) resolve(key string) (string, error) {
+	if key == "" || strings.HasPrefix(key, "/") || strings.ContainsAny(key, "\x00\\") {
+		return "", ErrInvalidPath
+	}
+
+	for _, segment := range strings.Split(key, "/") {
+		if segment == ".." {
+			return "", ErrInvalidPath
+		}
+	}
+
+	name := filepath.Join(s.root, filepath.FromSlash(key))
+	if !s.isWithinRoot(name) {
+		return "", ErrInvalidPath
+	}
+
+	if s.symlinks == SymlinksFollow {
+		return name, nil
+	}
+
+	resolved, err := filepath.EvalSymlinks(name)
+	if err != nil {
+		return "", err
+	}
+
+	if s.symlinks == SymlinksDeny && resolved != name {
+		return "", ErrSymlink
+	}
+
+	if !s.isWithinRoot(resolved) {
+		return "", ErrInvalidPath
+	}
+
+	return resolved, nil
+}
+
+func (s *FilesystemSource) Fetch(ctx context.Context, key string, conditions *Conditions) (*Object, error) {
+	// Local reads are quick, the context is only checked before starting
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	name, err := s.resolve(key)
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	if !info.Mode().IsRegular() {
+		file.Close()
+		return nil, fmt.Errorf("%s is not a regular file", key)
+	}
+
+	etag := fmt.Sprintf(`"%x-%x"`, info.ModTime().UnixNano(), info.Size())
+	if conditions.NotModified(etag, info.ModTime()) {
+		file.Close()
+		return nil, ErrNotModified
+	}
+
+	return &Object{
+		Body:         file,
+		ETag:         etag,
+		LastModified: info.ModTime(),
+	}, nil
+}
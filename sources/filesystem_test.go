@@ -0,0 +1,172 @@
+package sources
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewFilesystemSource(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name     string
+		config   *FilesystemConfig
+		expected string
+	}{
+		{
+			name:     "Config is nil",
+			config:   nil,
+			expected: "FilesystemSource Config is nil!",
+		},
+		{
+			name:     "cfg.Root is blank",
+			config:   &FilesystemConfig{},
+			expected: "cfg.Root is blank!",
+		},
+		{
+			name:     "unsupported symlink policy",
+			config:   &FilesystemConfig{Root: t.TempDir(), Symlinks: "sometimes"},
+			expected: "unsupported symlink policy: sometimes",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewFilesystemSource(tt.config)
+			assert.Equal(t, tt.expected, err.Error())
+		})
+	}
+}
+
+// Creates a root containing products/1.jpg, a file outside of the root and symlinks pointing to both.
+func setupFilesystemRoot(t *testing.T) string {
+	dir := t.TempDir()
+	root := filepath.Join(dir, "root")
+
+	require.NoError(t, os.MkdirAll(filepath.Join(root, "products"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(root, "products", "1.jpg"), []byte("image"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "secret.jpg"), []byte("secret"), 0644))
+	require.NoError(t, os.Symlink(filepath.Join(root, "products", "1.jpg"), filepath.Join(root, "inside.jpg")))
+	require.NoError(t, os.Symlink(filepath.Join(dir, "secret.jpg"), filepath.Join(root, "outside.jpg")))
+
+	return root
+}
+
+func TestFilesystemSource_Fetch(t *testing.T) {
+	t.Parallel()
+
+	root := setupFilesystemRoot(t)
+	source, err := NewFilesystemSource(&FilesystemConfig{Root: root})
+	require.NoError(t, err)
+
+	t.Run("existing file", func(t *testing.T) {
+		object, err := source.Fetch(context.Background(), "products/1.jpg", nil)
+		require.NoError(t, err)
+		defer object.Body.Close()
+
+		data, err := io.ReadAll(object.Body)
+		assert.NoError(t, err)
+		assert.Equal(t, "image", string(data))
+		assert.False(t, object.LastModified.IsZero())
+		assert.NotEmpty(t, object.ETag)
+	})
+
+	t.Run("missing file", func(t *testing.T) {
+		_, err := source.Fetch(context.Background(), "products/2.jpg", nil)
+		assert.True(t, os.IsNotExist(err))
+	})
+
+	t.Run("directory", func(t *testing.T) {
+		_, err := source.Fetch(context.Background(), "products", nil)
+		assert.Error(t, err)
+	})
+
+	t.Run("path traversal", func(t *testing.T) {
+		for _, key := range []string{"../secret.jpg", "products/../../secret.jpg", "/etc/passwd", "", "products\\..\\1.jpg"} {
+			_, err := source.Fetch(context.Background(), key, nil)
+			assert.ErrorIs(t, err, ErrInvalidPath, key)
+		}
+	})
+}
+
+func TestFilesystemSource_Symlinks(t *testing.T) {
+	t.Parallel()
+
+	root := setupFilesystemRoot(t)
+
+	tests := []struct {
+		name           string
+		policy         SymlinkPolicy
+		key            string
+		expectedError  error
+		expectedResult string
+	}{
+		{name: "deny inside root", policy: SymlinksDeny, key: "inside.jpg", expectedError: ErrSymlink},
+		{name: "deny outside root", policy: SymlinksDeny, key: "outside.jpg", expectedError: ErrSymlink},
+		{name: "within root inside root", policy: SymlinksWithinRoot, key: "inside.jpg", expectedResult: "image"},
+		{name: "within root outside root", policy: SymlinksWithinRoot, key: "outside.jpg", expectedError: ErrInvalidPath},
+		{name: "follow outside root", policy: SymlinksFollow, key: "outside.jpg", expectedResult: "secret"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			source, err := NewFilesystemSource(&FilesystemConfig{Root: root, Symlinks: tt.policy})
+			require.NoError(t, err)
+
+			object, err := source.Fetch(context.Background(), tt.key, nil)
+			if tt.expectedError != nil {
+				assert.ErrorIs(t, err, tt.expectedError)
+				return
+			}
+
+			require.NoError(t, err)
+			defer object.Body.Close()
+
+			data, err := io.ReadAll(object.Body)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expectedResult, string(data))
+		})
+	}
+}
+
+func TestFilesystemSource_ETag(t *testing.T) {
+	t.Parallel()
+
+	root := setupFilesystemRoot(t)
+	source, err := NewFilesystemSource(&FilesystemConfig{Root: root})
+	require.NoError(t, err)
+
+	object, err := source.Fetch(context.Background(), "products/1.jpg", nil)
+	require.NoError(t, err)
+	object.Body.Close()
+
+	again, err := source.Fetch(context.Background(), "products/1.jpg", nil)
+	require.NoError(t, err)
+	again.Body.Close()
+	assert.Equal(t, object.ETag, again.ETag, "ETag should be stable while the file is unchanged")
+
+	_, err = source.Fetch(context.Background(), "products/1.jpg", &Conditions{IfNoneMatch: object.ETag})
+	assert.ErrorIs(t, err, ErrNotModified)
+
+	_, err = source.Fetch(context.Background(), "products/1.jpg", &Conditions{IfModifiedSince: object.LastModified})
+	assert.ErrorIs(t, err, ErrNotModified)
+
+	modified := time.Now().Add(time.Hour)
+	require.NoError(t, os.Chtimes(filepath.Join(root, "products", "1.jpg"), modified, modified))
+
+	changed, err := source.Fetch(context.Background(), "products/1.jpg", nil)
+	require.NoError(t, err)
+	changed.Body.Close()
+	assert.NotEqual(t, object.ETag, changed.ETag, "ETag should change with the modification time")
+
+	changed, err = source.Fetch(context.Background(), "products/1.jpg", &Conditions{IfNoneMatch: object.ETag})
+	require.NoError(t, err, "modified files should be fetched again")
+	changed.Body.Close()
+}
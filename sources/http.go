@@ -0,0 +1,257 @@
+package sources
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+var ErrTooLarge = errors.New("upstream response exceeds the maximum size")
+
+type BasicAuthConfig struct {
+	UsernameEnv string `json:"username_env"`
+	PasswordEnv string `json:"password_env"`
+}
+
+// FetchConfig customizes requests made to an upstream host. Secrets are never part of the configuration, only the
+// names of the environment variables holding them, so the configuration itself is always safe to log.
+type FetchConfig struct {
+	Headers        map[string]string `json:"headers"`
+	UserAgent      string            `json:"user_agent"`
+	BearerTokenEnv string            `json:"bearer_token_env"`
+	BasicAuth      *BasicAuthConfig  `json:"basic_auth"`
+	Timeout        string            `json:"timeout"`   // e.g. 5s
+	MaxBytes       int64             `json:"max_bytes"` // 0 for unlimited
+
+	timeout time.Duration
+}
+
+func (f *FetchConfig) validate() error {
+	if f.Timeout != "" {
+		timeout, err := time.ParseDuration(f.Timeout)
+		if err != nil {
+			return fmt.Errorf("invalid timeout: %v", err)
+		}
+		f.timeout = timeout
+	}
+
+	if f.MaxBytes < 0 {
+		return fmt.Errorf("max_bytes must not be negative")
+	}
+
+	return nil
+}
+
+// Applies headers and credentials to the request, reading secrets from the environment at request time.
+func (f *FetchConfig) apply(req *http.Request) {
+	for key, value := range f.Headers {
+		req.Header.Set(key, value)
+	}
+
+	if f.UserAgent != "" {
+		req.Header.Set("User-Agent", f.UserAgent)
+	}
+
+	if f.BasicAuth != nil {
+		req.SetBasicAuth(os.Getenv(f.BasicAuth.UsernameEnv), os.Getenv(f.BasicAuth.PasswordEnv))
+	}
+
+	if f.BearerTokenEnv != "" {
+		req.Header.Set("Authorization", "Bearer "+os.Getenv(f.BearerTokenEnv))
+	}
+}
+
+// LoadFetchConfigs reads a JSON file mapping upstream domains to their fetch configuration, e.g.
+// {"images.example.com": {"bearer_token_env": "EXAMPLE_TOKEN", "timeout": "5s", "max_bytes": 10485760}}
+func LoadFetchConfigs(path string) (map[string]*FetchConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	configs := map[string]*FetchConfig{}
+	err = json.Unmarshal(data, &configs)
+	if err != nil {
+		return nil, fmt.Errorf("invalid fetch config file %s: %v", path, err)
+	}
+
+	for domain, cfg := range configs {
+		if domain == "" || cfg == nil {
+			return nil, fmt.Errorf("invalid fetch config file %s: invalid entry for domain %q", path, domain)
+		}
+	}
+
+	return configs, nil
+}
+
+type HTTPConfig struct {
+	BaseURL string // Optional, when blank keys are absolute URLs
+	Client  *http.Client
+	Fetch   *FetchConfig            // Optional, applied to every request
+	Domains map[string]*FetchConfig // Optional, applied to requests for the domain and its subdomains
+}
+
+// HTTPSource fetches originals from HTTP hosts.
+type HTTPSource struct {
+	baseURL string
+	client  *http.Client
+	fetch   *FetchConfig
+	domains map[string]*FetchConfig
+}
+
+func NewHTTPSource(cfg *HTTPConfig) (*HTTPSource, error) {
+	if cfg == nil {
+		return nil, fmt.Errorf("HTTPSource Config is nil!")
+	}
+
+	if cfg.Client == nil {
+		cfg.Client = http.DefaultClient
+	}
+
+	if cfg.Fetch == nil {
+		cfg.Fetch = &FetchConfig{}
+	}
+
+	err := cfg.Fetch.validate()
+	if err != nil {
+		return nil, err
+	}
+
+	domains := map[string]*FetchConfig{}
+	for domain, fetch := range cfg.Domains {
+		err = fetch.validate()
+		if err != nil {
+			return nil, fmt.Errorf("domain %s: %v", domain, err)
+		}
+		domains[strings.ToLower(domain)] = fetch
+	}
+
+	return &HTTPSource{
+		baseURL: cfg.BaseURL,
+		client:  cfg.Client,
+		fetch:   cfg.Fetch,
+		domains: domains,
+	}, nil
+}
+
+func (s *HTTPSource) url(key string) string {
+	if s.baseURL == "" {
+		return key
+	}
+	return strings.TrimSuffix(s.baseURL, "/") + "/" + strings.TrimPrefix(key, "/")
+}
+
+// Returns the configuration of the most specific domain matching the host, falling back to the source wide one.
+func (s *HTTPSource) fetchConfig(host string) *FetchConfig {
+	host = strings.ToLower(host)
+	for {
+		if fetch, ok := s.domains[host]; ok {
+			return fetch
+		}
+
+		_, parent, ok := strings.Cut(host, ".")
+		if !ok {
+			return s.fetch
+		}
+		host = parent
+	}
+}
+
+func (s *HTTPSource) Fetch(ctx context.Context, key string, conditions *Conditions) (*Object, error) {
+	target, err := url.Parse(s.url(key))
+	if err != nil {
+		return nil, err
+	}
+
+	fetch := s.fetchConfig(target.Hostname())
+
+	cancel := func() {}
+	if fetch.timeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, fetch.timeout)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target.String(), nil)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	fetch.apply(req)
+	conditions.apply(req.Header)
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	if resp.StatusCode == http.StatusNotModified {
+		resp.Body.Close()
+		cancel()
+		return nil, ErrNotModified
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		cancel()
+		return nil, fmt.Errorf("upstream responded with %s", resp.Status)
+	}
+
+	if fetch.MaxBytes > 0 && resp.ContentLength > fetch.MaxBytes {
+		resp.Body.Close()
+		cancel()
+		return nil, ErrTooLarge
+	}
+
+	lastModified, _ := time.Parse(http.TimeFormat, resp.Header.Get("Last-Modified"))
+
+	return &Object{
+		Body:         &responseBody{body: resp.Body, cancel: cancel, remaining: fetch.MaxBytes, limited: fetch.MaxBytes > 0},
+		ContentType:  resp.Header.Get("Content-Type"),
+		ETag:         resp.Header.Get("ETag"),
+		LastModified: lastModified,
+	}, nil
+}
+
+// Enforces the maximum size while reading and releases the request timeout once closed.
+type responseBody struct {
+	body      io.ReadCloser
+	cancel    context.CancelFunc
+	remaining int64
+	limited   bool
+}
+
+func (b *responseBody) Read(p []byte) (int, error) {
+	if !b.limited {
+		return b.body.Read(p)
+	}
+
+	if b.remaining <= 0 {
+		// Only an error if there is more to read
+		var probe [1]byte
+		n, err := b.body.Read(probe[:])
+		if n > 0 {
+			return 0, ErrTooLarge
+		}
+		return 0, err
+	}
+
+	if int64(len(p)) > b.remaining {
+		p = p[:b.remaining]
+	}
+
+	n, err := b.body.Read(p)
+	b.remaining -= int64(n)
+	return n, err
+}
+
+func (b *responseBody) Close() error {
+	defer b.cancel()
+	return b.body.Close()
+}
@@ -0,0 +1,225 @@
+package sources
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHTTPSource_Fetch(t *testing.T) {
+	t.Parallel()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/images/photo.jpg" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		if r.Header.Get("If-None-Match") == `"abc"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+
+		w.Header().Set("Content-Type", "image/jpeg")
+		w.Header().Set("ETag", `"abc"`)
+		w.Header().Set("Last-Modified", "Wed, 21 Oct 2015 07:28:00 GMT")
+		_, _ = w.Write([]byte("image"))
+	}))
+	defer server.Close()
+
+	t.Run("absolute URL", func(t *testing.T) {
+		source, err := NewHTTPSource(&HTTPConfig{})
+		require.NoError(t, err)
+
+		object, err := source.Fetch(context.Background(), server.URL+"/images/photo.jpg", nil)
+		require.NoError(t, err)
+		defer object.Body.Close()
+
+		data, err := io.ReadAll(object.Body)
+		assert.NoError(t, err)
+		assert.Equal(t, "image", string(data))
+		assert.Equal(t, "image/jpeg", object.ContentType)
+		assert.Equal(t, `"abc"`, object.ETag)
+		assert.Equal(t, int64(1445412480), object.LastModified.Unix())
+	})
+
+	t.Run("base URL", func(t *testing.T) {
+		source, err := NewHTTPSource(&HTTPConfig{BaseURL: server.URL + "/images/"})
+		require.NoError(t, err)
+
+		object, err := source.Fetch(context.Background(), "photo.jpg", nil)
+		require.NoError(t, err)
+		object.Body.Close()
+	})
+
+	t.Run("conditional", func(t *testing.T) {
+		source, err := NewHTTPSource(&HTTPConfig{})
+		require.NoError(t, err)
+
+		_, err = source.Fetch(context.Background(), server.URL+"/images/photo.jpg", &Conditions{IfNoneMatch: `"abc"`})
+		assert.ErrorIs(t, err, ErrNotModified)
+
+		object, err := source.Fetch(context.Background(), server.URL+"/images/photo.jpg", &Conditions{IfNoneMatch: `"old"`})
+		require.NoError(t, err)
+		object.Body.Close()
+	})
+
+	t.Run("upstream error", func(t *testing.T) {
+		source, err := NewHTTPSource(&HTTPConfig{})
+		require.NoError(t, err)
+
+		_, err = source.Fetch(context.Background(), server.URL+"/missing.jpg", nil)
+		assert.Error(t, err)
+		assert.Contains(t, err.Error(), "404 Not Found")
+	})
+}
+
+func TestHTTPSource_FetchConfig(t *testing.T) {
+	t.Setenv("TEST_UPSTREAM_TOKEN", "s3cr3t")
+	t.Setenv("TEST_UPSTREAM_USER", "user")
+	t.Setenv("TEST_UPSTREAM_PASSWORD", "password")
+
+	// Echoes the upstream request headers the fetch configuration affects
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/slow.jpg":
+			time.Sleep(200 * time.Millisecond)
+		case "/large.jpg":
+			_, _ = w.Write(bytes.Repeat([]byte("x"), 1024))
+			return
+		case "/stalled.jpg":
+			// Sends the start of the body, then waits for the client to give up
+			_, _ = w.Write([]byte("partial"))
+			w.(http.Flusher).Flush()
+			<-r.Context().Done()
+			return
+		}
+
+		_, _ = fmt.Fprintf(w, "%s|%s|%s", r.Header.Get("Authorization"), r.Header.Get("User-Agent"), r.Header.Get("X-Custom"))
+	}))
+	defer server.Close()
+
+	target, err := url.Parse(server.URL)
+	require.NoError(t, err)
+
+	// Routes every host name to the test server so domain matching can be exercised
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, network, target.Host)
+		},
+	}}
+
+	fetch := func(t *testing.T, cfg *HTTPConfig, url string) (string, error) {
+		cfg.Client = client
+		source, err := NewHTTPSource(cfg)
+		require.NoError(t, err)
+
+		object, err := source.Fetch(context.Background(), url, nil)
+		if err != nil {
+			return "", err
+		}
+		defer object.Body.Close()
+
+		data, err := io.ReadAll(object.Body)
+		return string(data), err
+	}
+
+	t.Run("bearer token and headers", func(t *testing.T) {
+		body, err := fetch(t, &HTTPConfig{Domains: map[string]*FetchConfig{
+			"example.com": {
+				BearerTokenEnv: "TEST_UPSTREAM_TOKEN",
+				UserAgent:      "antman-proxy",
+				Headers:        map[string]string{"X-Custom": "value"},
+			},
+		}}, "http://images.example.com/photo.jpg")
+
+		assert.NoError(t, err)
+		assert.Equal(t, "Bearer s3cr3t|antman-proxy|value", body)
+	})
+
+	t.Run("basic auth", func(t *testing.T) {
+		body, err := fetch(t, &HTTPConfig{Domains: map[string]*FetchConfig{
+			"images.example.com": {BasicAuth: &BasicAuthConfig{UsernameEnv: "TEST_UPSTREAM_USER", PasswordEnv: "TEST_UPSTREAM_PASSWORD"}},
+		}}, "http://images.example.com/photo.jpg")
+
+		assert.NoError(t, err)
+		assert.Equal(t, "Basic dXNlcjpwYXNzd29yZA==|Go-http-client/1.1|", body)
+	})
+
+	t.Run("other domains are unaffected", func(t *testing.T) {
+		body, err := fetch(t, &HTTPConfig{Domains: map[string]*FetchConfig{
+			"example.com": {BearerTokenEnv: "TEST_UPSTREAM_TOKEN"},
+		}}, "http://notexample.com/photo.jpg")
+
+		assert.NoError(t, err)
+		assert.Equal(t, "|Go-http-client/1.1|", body)
+	})
+
+	t.Run("timeout", func(t *testing.T) {
+		_, err := fetch(t, &HTTPConfig{Fetch: &FetchConfig{Timeout: "50ms"}}, server.URL+"/slow.jpg")
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+	})
+
+	t.Run("canceled mid-fetch", func(t *testing.T) {
+		source, err := NewHTTPSource(&HTTPConfig{Client: client})
+		require.NoError(t, err)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		object, err := source.Fetch(ctx, server.URL+"/stalled.jpg", nil)
+		require.NoError(t, err)
+		defer object.Body.Close()
+
+		start := make([]byte, len("partial"))
+		_, err = io.ReadFull(object.Body, start)
+		require.NoError(t, err)
+
+		time.AfterFunc(20*time.Millisecond, cancel)
+		_, err = io.ReadAll(object.Body)
+		assert.ErrorIs(t, err, context.Canceled)
+	})
+
+	t.Run("max bytes", func(t *testing.T) {
+		_, err := fetch(t, &HTTPConfig{Fetch: &FetchConfig{MaxBytes: 512}}, server.URL+"/large.jpg")
+		assert.ErrorIs(t, err, ErrTooLarge)
+
+		_, err = fetch(t, &HTTPConfig{Fetch: &FetchConfig{MaxBytes: 1024}}, server.URL+"/large.jpg")
+		assert.NoError(t, err)
+	})
+
+	t.Run("invalid timeout", func(t *testing.T) {
+		_, err := NewHTTPSource(&HTTPConfig{Domains: map[string]*FetchConfig{"example.com": {Timeout: "soon"}}})
+		assert.Error(t, err)
+		assert.Contains(t, err.Error(), "domain example.com: invalid timeout")
+	})
+}
+
+func TestLoadFetchConfigs(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "fetch.json")
+	err := os.WriteFile(path, []byte(`{"images.example.com": {"bearer_token_env": "EXAMPLE_TOKEN", "timeout": "5s", "max_bytes": 1048576}}`), 0644)
+	require.NoError(t, err)
+
+	configs, err := LoadFetchConfigs(path)
+	require.NoError(t, err)
+	assert.Equal(t, "EXAMPLE_TOKEN", configs["images.example.com"].BearerTokenEnv)
+	assert.Equal(t, int64(1048576), configs["images.example.com"].MaxBytes)
+
+	err = os.WriteFile(path, []byte(`{"images.example.com": null}`), 0644)
+	require.NoError(t, err)
+
+	_, err = LoadFetchConfigs(path)
+	assert.Error(t, err)
+}
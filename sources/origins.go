@@ -0,0 +1,94 @@
+package sources
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"antman-proxy/clients/s3"
+)
+
+// OriginConfig describes a named backend originals can be fetched from with src=name:key.
+// Credentials are never stored in the file, only the names of the environment variables holding them.
+type OriginConfig struct {
+	Type         string       `json:"type"` // http, filesystem or s3
+	BaseURL      string       `json:"base_url"`
+	Fetch        *FetchConfig `json:"fetch"` // Headers, credentials and limits for http origins
+	Root         string       `json:"root"`
+	Symlinks     string       `json:"symlinks"` // deny, within_root or follow
+	Endpoint     string       `json:"endpoint"`
+	Region       string       `json:"region"`
+	Bucket       string       `json:"bucket"`
+	Prefix       string       `json:"prefix"`
+	AccessKeyEnv string       `json:"access_key_env"`
+	SecretKeyEnv string       `json:"secret_key_env"`
+}
+
+// Names that would be ambiguous with absolute URLs.
+var reservedOriginNames = []string{"http", "https"}
+
+func NewSource(cfg *OriginConfig) (Source, error) {
+	if cfg == nil {
+		return nil, fmt.Errorf("OriginConfig is nil!")
+	}
+
+	switch cfg.Type {
+	case "http":
+		if cfg.BaseURL == "" {
+			return nil, fmt.Errorf("base_url is required for http origins")
+		}
+		return NewHTTPSource(&HTTPConfig{BaseURL: cfg.BaseURL, Fetch: cfg.Fetch})
+	case "filesystem":
+		return NewFilesystemSource(&FilesystemConfig{Root: cfg.Root, Symlinks: SymlinkPolicy(cfg.Symlinks)})
+	case "s3":
+		client, err := s3.NewClient(&s3.Config{
+			Endpoint:  cfg.Endpoint,
+			Region:    cfg.Region,
+			Bucket:    cfg.Bucket,
+			AccessKey: os.Getenv(cfg.AccessKeyEnv),
+			SecretKey: os.Getenv(cfg.SecretKeyEnv),
+		})
+		if err != nil {
+			return nil, err
+		}
+		return NewS3Source(&S3Config{Client: client, Prefix: cfg.Prefix})
+	default:
+		return nil, fmt.Errorf("unsupported origin type: %s", cfg.Type)
+	}
+}
+
+// LoadOrigins reads a JSON file mapping origin names to their configuration, e.g.
+// {"bucket": {"type": "s3", "endpoint": "http://localhost:9000", "bucket": "products"}}
+func LoadOrigins(path string) (map[string]Source, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	configs := map[string]*OriginConfig{}
+	err = json.Unmarshal(data, &configs)
+	if err != nil {
+		return nil, fmt.Errorf("invalid origins file %s: %v", path, err)
+	}
+
+	origins := map[string]Source{}
+	for name, cfg := range configs {
+		if name == "" || strings.Contains(name, ":") {
+			return nil, fmt.Errorf("invalid origins file %s: invalid origin name %q", path, name)
+		}
+
+		for _, reserved := range reservedOriginNames {
+			if strings.EqualFold(name, reserved) {
+				return nil, fmt.Errorf("invalid origins file %s: origin name %q is reserved", path, name)
+			}
+		}
+
+		origins[name], err = NewSource(cfg)
+		if err != nil {
+			return nil, fmt.Errorf("invalid origins file %s: origin %q: %v", path, name, err)
+		}
+	}
+
+	return origins, nil
+}
@@ -0,0 +1,68 @@
+package sources
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadOrigins(t *testing.T) {
+	t.Parallel()
+
+	root := t.TempDir()
+
+	tests := []struct {
+		name          string
+		content       string
+		expected      []string
+		expectedError string
+	}{
+		{
+			name: "valid origins",
+			content: `{
+				"cdn": {"type": "http", "base_url": "https://images.example.com"},
+				"local": {"type": "filesystem", "root": "` + root + `"},
+				"bucket": {"type": "s3", "endpoint": "http://localhost:9000", "bucket": "products"}
+			}`,
+			expected: []string{"bucket", "cdn", "local"},
+		},
+		{
+			name:          "reserved name",
+			content:       `{"https": {"type": "http", "base_url": "https://images.example.com"}}`,
+			expectedError: `origin name "https" is reserved`,
+		},
+		{
+			name:          "unsupported type",
+			content:       `{"ftp": {"type": "ftp"}}`,
+			expectedError: "unsupported origin type: ftp",
+		},
+		{
+			name:          "missing bucket",
+			content:       `{"bucket": {"type": "s3", "endpoint": "http://localhost:9000"}}`,
+			expectedError: "cfg.Bucket is blank!",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "origins.json")
+			require.NoError(t, os.WriteFile(path, []byte(tt.content), 0644))
+
+			origins, err := LoadOrigins(path)
+			if tt.expectedError != "" {
+				assert.Error(t, err)
+				assert.Contains(t, err.Error(), tt.expectedError)
+				return
+			}
+
+			assert.NoError(t, err)
+			for _, name := range tt.expected {
+				assert.Contains(t, origins, name)
+			}
+			assert.Len(t, origins, len(tt.expected))
+		})
+	}
+}
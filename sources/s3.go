@@ -0,0 +1,64 @@
+package sources
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"antman-proxy/clients/s3"
+)
+
+type S3Config struct {
+	Client *s3.Client
+	Prefix string // Optional key prefix within the bucket
+}
+
+// S3Source fetches originals from an S3-compatible bucket.
+type S3Source struct {
+	client *s3.Client
+	prefix string
+}
+
+func NewS3Source(cfg *S3Config) (*S3Source, error) {
+	if cfg == nil {
+		return nil, fmt.Errorf("S3Source Config is nil!")
+	}
+
+	if cfg.Client == nil {
+		return nil, fmt.Errorf("cfg.Client is nil!")
+	}
+
+	return &S3Source{
+		client: cfg.Client,
+		prefix: cfg.Prefix,
+	}, nil
+}
+
+func (s *S3Source) Fetch(ctx context.Context, key string, conditions *Conditions) (*Object, error) {
+	req, err := s.client.NewRequest(http.MethodGet, s.prefix+key, nil)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	conditions.apply(req.Header)
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode == http.StatusNotModified {
+		resp.Body.Close()
+		return nil, ErrNotModified
+	}
+
+	lastModified, _ := time.Parse(http.TimeFormat, resp.Header.Get("Last-Modified"))
+
+	return &Object{
+		Body:         resp.Body,
+		ContentType:  resp.Header.Get("Content-Type"),
+		ETag:         resp.Header.Get("ETag"),
+		LastModified: lastModified,
+	}, nil
+}
@@ -0,0 +1,69 @@
+package sources
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"antman-proxy/clients/s3"
+)
+
+func TestS3Source_Fetch(t *testing.T) {
+	t.Parallel()
+
+	// Stands in for an S3-compatible server such as MinIO
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") == "" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+
+		if r.URL.Path != "/products/originals/123.jpg" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		if r.Header.Get("If-None-Match") == `"d41d8cd98f00b204e9800998ecf8427e"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+
+		w.Header().Set("Content-Type", "image/jpeg")
+		w.Header().Set("ETag", `"d41d8cd98f00b204e9800998ecf8427e"`)
+		_, _ = w.Write([]byte("image"))
+	}))
+	defer server.Close()
+
+	client, err := s3.NewClient(&s3.Config{Endpoint: server.URL, Bucket: "products", AccessKey: "access", SecretKey: "secret"})
+	require.NoError(t, err)
+
+	source, err := NewS3Source(&S3Config{Client: client, Prefix: "originals/"})
+	require.NoError(t, err)
+
+	t.Run("existing object", func(t *testing.T) {
+		object, err := source.Fetch(context.Background(), "123.jpg", nil)
+		require.NoError(t, err)
+		defer object.Body.Close()
+
+		data, err := io.ReadAll(object.Body)
+		assert.NoError(t, err)
+		assert.Equal(t, "image", string(data))
+		assert.Equal(t, "image/jpeg", object.ContentType)
+		assert.Equal(t, `"d41d8cd98f00b204e9800998ecf8427e"`, object.ETag)
+	})
+
+	t.Run("not modified", func(t *testing.T) {
+		_, err := source.Fetch(context.Background(), "123.jpg", &Conditions{IfNoneMatch: `"d41d8cd98f00b204e9800998ecf8427e"`})
+		assert.ErrorIs(t, err, ErrNotModified)
+	})
+
+	t.Run("missing object", func(t *testing.T) {
+		_, err := source.Fetch(context.Background(), "456.jpg", nil)
+		assert.ErrorIs(t, err, s3.ErrNotFound)
+	})
+}
@@ -0,0 +1,60 @@
+package sources
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"time"
+)
+
+// ErrNotModified is returned by Fetch when the original still matches the given Conditions.
+var ErrNotModified = errors.New("not modified")
+
+// Object is an original image fetched from a Source, the caller is responsible for closing Body.
+type Object struct {
+	Body         io.ReadCloser
+	ContentType  string
+	ETag         string
+	LastModified time.Time
+}
+
+// Conditions make a fetch conditional on the original having changed since it was last fetched.
+type Conditions struct {
+	IfNoneMatch     string
+	IfModifiedSince time.Time
+}
+
+// Reports whether an original with the given validators still satisfies the conditions, i.e. is not modified.
+func (c *Conditions) NotModified(etag string, lastModified time.Time) bool {
+	if c == nil {
+		return false
+	}
+
+	if c.IfNoneMatch != "" {
+		return c.IfNoneMatch == etag
+	}
+
+	return !c.IfModifiedSince.IsZero() && !lastModified.IsZero() && !lastModified.Truncate(time.Second).After(c.IfModifiedSince)
+}
+
+// Sets the conditional request headers on an upstream request.
+func (c *Conditions) apply(header http.Header) {
+	if c == nil {
+		return
+	}
+
+	if c.IfNoneMatch != "" {
+		header.Set("If-None-Match", c.IfNoneMatch)
+	}
+
+	if !c.IfModifiedSince.IsZero() {
+		header.Set("If-Modified-Since", c.IfModifiedSince.UTC().Format(http.TimeFormat))
+	}
+}
+
+type Source interface {
+	// Fetch returns the original for the key, or ErrNotModified if conditions is non-nil and still satisfied. Canceling
+	// ctx aborts the fetch, including reading the body of the returned object.
+	Fetch(ctx context.Context, key string, conditions *Conditions) (*Object, error)
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"runtime"
+	"strconv"
+	"strings"
+	"syscall"
+
+	imageHandler "antman-proxy/handlers/image"
+	imageManager "antman-proxy/managers/image"
+)
+
+const warmUsage = `Usage: antman-proxy warm [flags] <file>
+
+Pre-generates the variants of the configured presets for every image listed in the file, one URL or origin
+reference per line (blank lines and lines starting with # are skipped). Use - to read from stdin.
+
+Flags:
+`
+
+// Runs the warm subcommand, returning the exit code: 1 when variants failed and 2 for invalid usage.
+func warm(args []string) int {
+	flags := flag.NewFlagSet("warm", flag.ContinueOnError)
+	presetNames := flags.String("presets", "", "Comma separated presets to generate, defaults to every preset")
+	concurrency := flags.Int("concurrency", runtime.NumCPU(), "Number of variants processed at once")
+	flags.Usage = func() {
+		fmt.Fprint(flags.Output(), warmUsage)
+		flags.PrintDefaults()
+	}
+
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return 2
+	}
+
+	urls, err := readURLs(flags.Arg(0))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	// Variants are processed by a pool of background workers, within the memory budget configured for the server
+	memoryBudget, _ := strconv.ParseInt(os.Getenv("MEMORY_BUDGET_BYTES"), 10, 64)
+	workerPool := imageHandler.NewWorkerPool(&imageHandler.WorkerPoolConfig{
+		Workers:      *concurrency,
+		MemoryBudget: memoryBudget,
+	})
+	defer workerPool.Shutdown(context.Background())
+
+	app := newComponents(workerPool)
+	defer app.close()
+
+	variants := map[string]imageManager.Variant{}
+	for name, preset := range app.presets {
+		variants[name] = preset.Variant()
+	}
+
+	if *presetNames != "" {
+		selected := map[string]imageManager.Variant{}
+		for _, name := range strings.Split(*presetNames, ",") {
+			name = strings.TrimSpace(name)
+			variant, ok := variants[name]
+			if !ok {
+				fmt.Fprintf(os.Stderr, "Unknown preset: %s\n", name)
+				return 2
+			}
+			selected[name] = variant
+		}
+		variants = selected
+	}
+
+	if len(variants) == 0 {
+		fmt.Fprintln(os.Stderr, "No presets configured, set PRESETS_FILE")
+		return 2
+	}
+
+	// Interrupting the command stops the variants in progress instead of waiting for them
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	manager := imageHandler.NewPooledManager(app.imageManager, workerPool, imageHandler.PriorityBackground)
+	report := imageManager.Warm(ctx, manager, urls, variants, *concurrency)
+
+	for _, failure := range report.Failures {
+		fmt.Printf("FAIL %s [%s]: %s\n", failure.URL, failure.Variant, failure.Error)
+	}
+	fmt.Printf("%d variants generated, %d failed\n", report.Succeeded, report.Failed)
+
+	if report.Failed > 0 {
+		return 1
+	}
+	return 0
+}
+
+func readURLs(path string) ([]string, error) {
+	var reader io.Reader = os.Stdin
+	if path != "-" {
+		file, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		defer file.Close()
+		reader = file
+	}
+
+	var urls []string
+	scanner := bufio.NewScanner(reader)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		urls = append(urls, line)
+	}
+
+	return urls, scanner.Err()
+}